@@ -0,0 +1,161 @@
+package json
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// extensionByMediaType gives the extensions of the usual attachments, as the
+// ones known to the mime package depend on the system
+var extensionByMediaType = map[string]string{
+	"application/json": ".json",
+	"application/pdf":  ".pdf",
+	"application/xml":  ".xml",
+	"image/gif":        ".gif",
+	"image/jpeg":       ".jpg",
+	"image/png":        ".png",
+	"image/svg+xml":    ".svg",
+	"image/webp":       ".webp",
+	"text/html":        ".html",
+	"text/plain":       ".txt",
+	"text/xml":         ".xml",
+	"video/mp4":        ".mp4",
+	"video/webm":       ".webm",
+}
+
+// externalizeEmbeddings writes the embeddings of the elements to files in
+// AttachmentsDir, except for the text ones of at most InlineAttachmentMaxSize
+// bytes and the ones which are not valid base64, which stay inline.
+func (self *Formatter) externalizeEmbeddings(jsonElements []*jsonFeatureElement) error {
+	if self.AttachmentsDir == "" {
+		return nil
+	}
+
+	for _, jsonElement := range jsonElements {
+		err := self.externalizeStepsEmbeddings(jsonElement.Before)
+		if err == nil {
+			err = self.externalizeStepsEmbeddings(jsonElement.Steps)
+		}
+		if err == nil {
+			err = self.externalizeStepsEmbeddings(jsonElement.After)
+		}
+		if err == nil {
+			err = self.externalizeEmbeddingList(jsonElement.Embeddings)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *Formatter) externalizeStepsEmbeddings(jsonSteps []*jsonStep) error {
+	for _, jsonStep := range jsonSteps {
+		err := self.externalizeStepsEmbeddings(jsonStep.Before)
+		if err == nil {
+			err = self.externalizeStepsEmbeddings(jsonStep.After)
+		}
+		if err == nil {
+			err = self.externalizeEmbeddingList(jsonStep.Embeddings)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *Formatter) externalizeEmbeddingList(jsonEmbeddings []*jsonEmbedding) error {
+	for _, jsonEmbedding := range jsonEmbeddings {
+		err := self.externalizeEmbedding(jsonEmbedding)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *Formatter) externalizeEmbedding(jsonEmbedding *jsonEmbedding) error {
+	content, err := base64.StdEncoding.DecodeString(jsonEmbedding.Data)
+	if err != nil {
+		// The embedding is reported as it was attached
+		self.diagnostics = append(self.diagnostics, fmt.Errorf("the %s embedding is not valid base64: %s", jsonEmbedding.MimeType, err))
+		return nil
+	}
+	if isText(jsonEmbedding.MimeType) && len(content) <= self.InlineAttachmentMaxSize {
+		return nil
+	}
+
+	digest := sha256.Sum256(content)
+	fileName := hex.EncodeToString(digest[:]) + makeExtension(jsonEmbedding.MimeType)
+	filePath := filepath.Join(self.AttachmentsDir, fileName)
+
+	// The files are named after their content: an existing file is up to date
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		err = os.MkdirAll(self.AttachmentsDir, 0755)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filePath, content, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	relativePath, err := self.makeAttachmentPath(filePath)
+	if err != nil {
+		return err
+	}
+	jsonEmbedding.Data = ""
+	jsonEmbedding.Path = relativePath
+	return nil
+}
+
+// makeAttachmentPath returns the path of an attachment file relative to
+// ReportDir, with forward slashes so that it can be used as a URL
+func (self *Formatter) makeAttachmentPath(filePath string) (string, error) {
+	reportDir, err := filepath.Abs(self.ReportDir)
+	if err != nil {
+		return "", err
+	}
+	absolutePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	relativePath, err := filepath.Rel(reportDir, absolutePath)
+	if err != nil {
+		// e.g. on another Windows volume than the report
+		return filepath.ToSlash(absolutePath), nil
+	}
+	return filepath.ToSlash(relativePath), nil
+}
+
+func makeExtension(mediaType string) string {
+	mediaType, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return ".bin"
+	}
+	if extension, ok := extensionByMediaType[mediaType]; ok {
+		return extension
+	}
+	extensions, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(extensions) == 0 {
+		return ".bin"
+	}
+	return extensions[0]
+}
+
+func isText(mediaType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || mediaType == "application/xml"
+}
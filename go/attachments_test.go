@@ -0,0 +1,146 @@
+package json
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Formatter.externalizeEmbeddings", func() {
+	var (
+		formatter      *Formatter
+		attachmentsDir string
+		jsonElements   []*jsonFeatureElement
+		imageEmbedding *jsonEmbedding
+		textEmbedding  *jsonEmbedding
+	)
+
+	BeforeEach(func() {
+		tempDir, err := ioutil.TempDir("", "attachments")
+		Expect(err).To(BeNil())
+		attachmentsDir = filepath.Join(tempDir, "attachments")
+
+		formatter = &Formatter{
+			AttachmentsDir:          attachmentsDir,
+			ReportDir:               tempDir,
+			InlineAttachmentMaxSize: 5,
+		}
+		imageEmbedding = &jsonEmbedding{
+			Data:     base64.StdEncoding.EncodeToString([]byte("some image")),
+			MimeType: "image/png",
+		}
+		textEmbedding = &jsonEmbedding{
+			Data:     base64.StdEncoding.EncodeToString([]byte("text")),
+			MimeType: "text/plain;charset=utf-8",
+		}
+		jsonElements = []*jsonFeatureElement{
+			{
+				Steps: []*jsonStep{
+					{
+						Embeddings: []*jsonEmbedding{imageEmbedding, textEmbedding},
+					},
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(filepath.Dir(attachmentsDir))
+	})
+
+	It("writes the embeddings to files named after their content", func() {
+		Expect(formatter.externalizeEmbeddings(jsonElements)).To(Succeed())
+
+		digest := sha256.Sum256([]byte("some image"))
+		fileName := hex.EncodeToString(digest[:]) + ".png"
+		Expect(imageEmbedding.Path).To(Equal("attachments/" + fileName))
+		Expect(imageEmbedding.Data).To(BeEmpty())
+		content, err := ioutil.ReadFile(filepath.Join(attachmentsDir, fileName))
+		Expect(err).To(BeNil())
+		Expect(string(content)).To(Equal("some image"))
+	})
+
+	It("gives the path of the files relative to ReportDir", func() {
+		formatter.ReportDir = filepath.Join(filepath.Dir(attachmentsDir), "report")
+		Expect(formatter.externalizeEmbeddings(jsonElements)).To(Succeed())
+
+		Expect(imageEmbedding.Path).To(HavePrefix("../attachments/"))
+	})
+
+	It("gives the path of the files relative to the current directory without ReportDir", func() {
+		formatter.ReportDir = ""
+		Expect(formatter.externalizeEmbeddings(jsonElements)).To(Succeed())
+
+		workingDir, err := os.Getwd()
+		Expect(err).To(BeNil())
+		_, err = os.Stat(filepath.Join(workingDir, filepath.FromSlash(imageEmbedding.Path)))
+		Expect(err).To(BeNil())
+	})
+
+	It("keeps the small text embeddings inline", func() {
+		Expect(formatter.externalizeEmbeddings(jsonElements)).To(Succeed())
+
+		Expect(textEmbedding.Path).To(BeEmpty())
+		Expect(textEmbedding.Data).To(Equal(base64.StdEncoding.EncodeToString([]byte("text"))))
+	})
+
+	It("writes the text embeddings larger than InlineAttachmentMaxSize", func() {
+		formatter.InlineAttachmentMaxSize = 3
+		Expect(formatter.externalizeEmbeddings(jsonElements)).To(Succeed())
+
+		Expect(textEmbedding.Path).To(HaveSuffix(".txt"))
+		Expect(textEmbedding.Data).To(BeEmpty())
+	})
+
+	It("writes the embeddings of the hooks and of the elements", func() {
+		hookEmbedding := &jsonEmbedding{Data: imageEmbedding.Data, MimeType: "image/jpeg"}
+		elementEmbedding := &jsonEmbedding{Data: imageEmbedding.Data, MimeType: "video/mp4"}
+		jsonElements[0].Steps[0].After = []*jsonStep{{Embeddings: []*jsonEmbedding{hookEmbedding}}}
+		jsonElements[0].Embeddings = []*jsonEmbedding{elementEmbedding}
+
+		Expect(formatter.externalizeEmbeddings(jsonElements)).To(Succeed())
+
+		Expect(hookEmbedding.Path).To(HaveSuffix(".jpg"))
+		Expect(elementEmbedding.Path).To(HaveSuffix(".mp4"))
+	})
+
+	It("keeps the embeddings which are not valid base64 inline", func() {
+		invalidEmbedding := &jsonEmbedding{Data: "not base64!", MimeType: "image/png"}
+		jsonElements[0].Steps[0].Embeddings = append([]*jsonEmbedding{invalidEmbedding}, jsonElements[0].Steps[0].Embeddings...)
+
+		Expect(formatter.externalizeEmbeddings(jsonElements)).To(Succeed())
+
+		Expect(invalidEmbedding.Path).To(BeEmpty())
+		Expect(invalidEmbedding.Data).To(Equal("not base64!"))
+		Expect(imageEmbedding.Path).NotTo(BeEmpty())
+		Expect(len(formatter.Diagnostics())).To(Equal(1))
+	})
+
+	It("keeps every embedding inline without AttachmentsDir", func() {
+		formatter.AttachmentsDir = ""
+		Expect(formatter.externalizeEmbeddings(jsonElements)).To(Succeed())
+
+		Expect(imageEmbedding.Path).To(BeEmpty())
+		Expect(imageEmbedding.Data).NotTo(BeEmpty())
+		_, err := os.Stat(attachmentsDir)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
+
+var _ = Describe("makeExtension", func() {
+	It("returns the extension of the media type", func() {
+		Expect(makeExtension("image/png")).To(Equal(".png"))
+		Expect(makeExtension("text/plain; charset=utf-8")).To(Equal(".txt"))
+	})
+
+	It("returns .bin for unknown media types", func() {
+		Expect(makeExtension("application/x.unknown")).To(Equal(".bin"))
+		Expect(makeExtension("")).To(Equal(".bin"))
+	})
+})
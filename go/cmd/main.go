@@ -2,31 +2,265 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	jsonFormatter "github.com/cucumber/common/json-formatter/go/v19"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+const (
+	// exitTestFailure is the exit code of a test run which failed, with -exit-with-status
+	exitTestFailure = 1
+	// exitError is the exit code when the messages can not be converted
+	exitError = 2
+)
+
+// format is a report to write: the name of its reporter, and the file it is
+// written to, or STDOUT when there is none
+type format struct {
+	name string
+	path string
+}
+
+// formats collects the repeated -format flags
+type formats []*format
+
+func (self *formats) String() string {
+	values := make([]string, len(*self))
+	for index, format := range *self {
+		values[index] = format.name
+		if format.path != "" {
+			values[index] += ":" + format.path
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+func (self *formats) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	format := &format{name: parts[0]}
+	if len(parts) > 1 {
+		format.path = parts[1]
+	}
+	*self = append(*self, format)
+	return nil
+}
+
 func main() {
+	os.Exit(run())
+}
+
+// run converts the messages and returns the exit code, so that the deferred
+// calls run before exiting
+func run() int {
+	var reportFormats formats
+	flag.Var(&reportFormats, "format", fmt.Sprintf("the format of a report and the file it is written to, as name[:path], which can be repeated: %s (default json to STDOUT)", strings.Join(jsonFormatter.ReporterNames(), ", ")))
+	retryMode := flag.String("retry-mode", "all", "how retried test cases are reported: all, last or annotated")
+	usePickleName := flag.Bool("pickle-names", false, "use the pickle names, where the outline placeholders are substituted, as scenario names")
+	stepTimestamps := flag.Bool("step-timestamps", false, "write the start time of each step, next to the start time of the scenarios")
+	lenient := flag.Bool("lenient", false, "make a best-effort report out of a broken stream, listing the problems on STDERR")
+	dialect := flag.String("dialect", "", "make the JSON look like the one of cucumber-ruby, cucumber-jvm or cucumber-js")
+	verbose := flag.Bool("verbose", false, "write diagnostics (lookups, processing of each message and timing) to STDERR")
+	debug := flag.Bool("debug", false, "alias of -verbose")
+	logFile := flag.String("log-file", "", "write the diagnostics of -verbose to this file instead of STDERR")
+	attachmentsDir := flag.String("attachments-dir", "", "write the embeddings to files in this directory instead of inlining them in base64")
+	inlineAttachmentMaxSize := flag.Int("inline-attachment-max-size", 1024, "the size, in bytes, up to which the text embeddings stay inline with -attachments-dir")
+	rerunStatuses := flag.String("rerun-statuses", "failed,ambiguous,undefined,pending", "the statuses of the scenarios listed by the rerun format, separated by commas")
+	exitWithStatus := flag.Bool("exit-with-status", false, fmt.Sprintf("exit with %d when the test run failed, as told by TestRunFinished or else by the steps", exitTestFailure))
+	strict := flag.Bool("strict", false, "like -exit-with-status, the pending and undefined scenarios failing the test run as well")
+	stream := flag.Bool("stream", false, "write the JSON report while reading the messages, keeping the memory use flat")
 	flag.Parse()
 
 	var err error
-	var file *os.File
 	jf := &jsonFormatter.Formatter{}
+	jf.UsePickleName = *usePickleName
+	jf.StepStartTimestamps = *stepTimestamps
+	jf.Lenient = *lenient
+	jf.AttachmentsDir = *attachmentsDir
+	jf.InlineAttachmentMaxSize = *inlineAttachmentMaxSize
+	if *verbose || *debug {
+		logWriter := os.Stderr
+		if *logFile != "" {
+			logWriter, err = os.Create(*logFile)
+			if err != nil {
+				return fail(err)
+			}
+			defer logWriter.Close()
+		}
+		jf.Logger = log.New(logWriter, "", log.Lmicroseconds)
+	}
+	jf.RetryMode, err = jsonFormatter.ParseRetryMode(*retryMode)
+	if err != nil {
+		return fail(err)
+	}
+	jf.Dialect, err = jsonFormatter.ParseDialect(*dialect)
+	if err != nil {
+		return fail(err)
+	}
+	jf.StreamJSON = *stream
+	jf.RerunStatuses, err = jsonFormatter.ParseRerunStatuses(*rerunStatuses)
+	if err != nil {
+		return fail(err)
+	}
+	if len(reportFormats) == 0 {
+		reportFormats = formats{{name: "json"}}
+	}
+	if jf.AttachmentsDir != "" {
+		jf.ReportDir, err = attachmentsReportDir(reportFormats)
+		if err != nil {
+			return fail(err)
+		}
+	}
+	files, err := addReporters(jf, reportFormats)
+	// The files are closed once the reports are written, or else on return
+	defer func() { closeFiles(files) }()
+	if err != nil {
+		return fail(err)
+	}
 	paths := flag.Args()
-	if len(paths) > 1 {
-		for _, arg := range paths {
-			file, err = os.Open(arg)
+	if len(paths) > 0 {
+		for _, path := range paths {
+			err = readMessagesFile(jf, path)
 			if err != nil {
-				log.Fatal("ERROR: ", err)
+				return fail(err)
 			}
-			err = jf.ProcessMessages(file, os.Stdout)
-			log.Fatal("ERROR: ", err)
 		}
 	} else {
-		err = jf.ProcessMessages(os.Stdin, os.Stdout)
+		err = jf.ReadMessages(os.Stdin)
 		if err != nil {
-			log.Fatal("ERROR: ", err)
+			return fail(err)
 		}
 	}
+
+	err = jf.EndReport()
+	if err != nil {
+		return fail(err)
+	}
+	err = closeFiles(files)
+	files = nil
+	if err != nil {
+		return fail(err)
+	}
+
+	for _, diagnostic := range jf.Diagnostics() {
+		fmt.Fprintln(os.Stderr, "WARNING:", diagnostic)
+	}
+
+	if (*exitWithStatus || *strict) && !jf.Success(*strict) {
+		return exitTestFailure
+	}
+	return 0
+}
+
+func fail(err error) int {
+	log.Print("ERROR: ", err)
+	return exitError
+}
+
+// addReporters makes the reporters of the formats, writing to their file or to
+// STDOUT, which only one of them can use. It returns the files it created,
+// which are to be closed once the reports are written.
+func addReporters(jf *jsonFormatter.Formatter, reportFormats formats) ([]*os.File, error) {
+	err := checkFormats(jf, reportFormats)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*os.File, 0)
+	for _, format := range reportFormats {
+		writer := os.Stdout
+		if format.path != "" && format.path != "-" {
+			file, err := os.Create(format.path)
+			if err != nil {
+				return files, err
+			}
+			files = append(files, file)
+			writer = file
+		}
+
+		reporter, err := jsonFormatter.NewReporter(format.name, jf, writer)
+		if err != nil {
+			return files, err
+		}
+		jf.AddReporter(reporter)
+	}
+	return files, nil
+}
+
+// checkFormats tells whether the reporters of the formats can be made, before
+// any of their files is created
+func checkFormats(jf *jsonFormatter.Formatter, reportFormats formats) error {
+	reporterNames := jsonFormatter.ReporterNames()
+	stdoutFormat := ""
+	jsonFormat := false
+	for _, format := range reportFormats {
+		if !contains(reporterNames, format.name) {
+			return fmt.Errorf("unknown format %q (expected one of: %s)", format.name, strings.Join(reporterNames, ", "))
+		}
+		if format.path == "" || format.path == "-" {
+			if stdoutFormat != "" {
+				return fmt.Errorf("both %s and %s are written to STDOUT", stdoutFormat, format.name)
+			}
+			stdoutFormat = format.name
+		}
+		jsonFormat = jsonFormat || format.name == "json"
+	}
+	if jf.StreamJSON && !jsonFormat {
+		return fmt.Errorf("-stream only applies to the json format")
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// closeFiles closes every file, returning the first error
+func closeFiles(files []*os.File) error {
+	var firstErr error
+	for _, file := range files {
+		err := file.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// attachmentsReportDir returns the directory of the json and html reports,
+// which reference the files of -attachments-dir by their relative path: the
+// reports written to STDOUT are expected in the current directory
+func attachmentsReportDir(reportFormats formats) (string, error) {
+	reportDir := ""
+	for _, format := range reportFormats {
+		if format.name != "json" && format.name != "html" {
+			continue
+		}
+		dir := "."
+		if format.path != "" && format.path != "-" {
+			dir = filepath.Dir(format.path)
+		}
+		if reportDir != "" && dir != reportDir {
+			return "", fmt.Errorf("with -attachments-dir, the json and html reports must be in the same directory")
+		}
+		reportDir = dir
+	}
+	return reportDir, nil
+}
+
+func readMessagesFile(jf *jsonFormatter.Formatter, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return jf.ReadMessages(file)
 }
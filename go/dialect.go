@@ -0,0 +1,89 @@
+package json
+
+import "fmt"
+
+// Dialect tells which Cucumber implementation the JSON report looks like, for
+// the tools which only understand one of them
+type Dialect string
+
+const (
+	// DialectDefault is the JSON of cucumber-ruby, with the start time of the scenarios
+	DialectDefault Dialect = ""
+	// DialectRuby is the JSON of cucumber-ruby
+	DialectRuby Dialect = "cucumber-ruby"
+	// DialectJVM is the JSON of cucumber-jvm
+	DialectJVM Dialect = "cucumber-jvm"
+	// DialectJS is the JSON of cucumber-js
+	DialectJS Dialect = "cucumber-js"
+)
+
+// ParseDialect returns the Dialect named s
+func ParseDialect(s string) (Dialect, error) {
+	dialect := Dialect(s)
+	if _, ok := dialectProfiles[dialect]; ok {
+		return dialect, nil
+	}
+	return "", fmt.Errorf("unknown dialect %q (expected one of: cucumber-ruby, cucumber-jvm, cucumber-js)", s)
+}
+
+// dialectProfile lists what differs between the dialects. The durations are
+// nanoseconds in every dialect.
+type dialectProfile struct {
+	// startTimestamps gives the start time of the scenarios
+	startTimestamps bool
+	// durationOfStepsNotRun gives the duration of the skipped and undefined steps
+	durationOfStepsNotRun bool
+	// unmatchedStepLocations gives the location of the step in the feature file
+	// as the match of the undefined and ambiguous steps, which have no match otherwise
+	unmatchedStepLocations bool
+	// matchArguments gives the values captured by the step definition, with
+	// their offset, as the arguments of the match
+	matchArguments bool
+	// hooksAsSteps renders the hooks as hidden steps among the steps of the
+	// scenario, which includes the background steps
+	hooksAsSteps bool
+	// pickleIDs makes the ID of the scenarios out of the names of the feature
+	// and of the pickle only, rather than out of the names of the feature, the
+	// scenario and the examples, and of the row of the example
+	pickleIDs bool
+	// javaLocations renders the locations given by a Java stack trace element
+	// as ClassName.MethodName(FileName:line), rather than FileName:line. The
+	// Java methods are ClassName.MethodName(ParameterTypes) in every dialect.
+	javaLocations bool
+}
+
+var dialectProfiles = map[Dialect]*dialectProfile{
+	DialectDefault: {
+		startTimestamps:        true,
+		unmatchedStepLocations: true,
+		matchArguments:         true,
+	},
+	// lib/cucumber/formatter/json.rb of cucumber-ruby
+	DialectRuby: {
+		unmatchedStepLocations: true,
+	},
+	// io.cucumber.core.plugin.JsonFormatter of cucumber-jvm
+	DialectJVM: {
+		startTimestamps:       true,
+		durationOfStepsNotRun: true,
+		// TestStep.getCodeLocation(), from StackTraceElement.toString()
+		javaLocations: true,
+		// createMatchMap: the val and offset of each argument
+		matchArguments: true,
+	},
+	// src/formatter/json_formatter.ts of cucumber-js
+	DialectJS: {
+		durationOfStepsNotRun: true,
+		hooksAsSteps:          true,
+		// getScenarioData: `${featureId};${convertNameToId(pickle)}`
+		pickleIDs: true,
+	},
+}
+
+func (self Dialect) profile() *dialectProfile {
+	profile, ok := dialectProfiles[self]
+	if !ok {
+		return dialectProfiles[DialectDefault]
+	}
+	return profile
+}
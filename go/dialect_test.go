@@ -0,0 +1,55 @@
+package json
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// The messages of testdata/dialects/<dialect>/*.ndjson are the ones of the
+// implementation of the dialect, next to the JSON report it writes. The golden
+// files are written by hand, unlike the ones of ../testdata which are written by
+// cucumber-ruby: testdata/dialects/README.md lists what each of them asserts,
+// and where the implementation of the dialect does it.
+var _ = Describe("Formatter with a Dialect", func() {
+	messagesFiles, _ := filepath.Glob("testdata/dialects/*/*.ndjson")
+
+	for _, messagesFile := range messagesFiles {
+		messagesFile := messagesFile
+		dialect := Dialect(filepath.Base(filepath.Dir(messagesFile)))
+		goldenFile := strings.TrimSuffix(messagesFile, ".ndjson") + ".json"
+
+		It("renders "+filepath.Base(messagesFile)+" as "+string(dialect)+" does", func() {
+			expected, err := ioutil.ReadFile(goldenFile)
+			Expect(err).To(BeNil())
+			reader, err := os.Open(messagesFile)
+			Expect(err).To(BeNil())
+			defer reader.Close()
+
+			output := &bytes.Buffer{}
+			formatter := &Formatter{}
+			formatter.Dialect = dialect
+			Expect(formatter.ProcessMessages(reader, output)).To(Succeed())
+
+			Expect(output.String()).To(MatchJSON(expected))
+		})
+	}
+})
+
+var _ = Describe("ParseDialect", func() {
+	It("returns the Dialect", func() {
+		for _, dialect := range []Dialect{DialectDefault, DialectRuby, DialectJVM, DialectJS} {
+			Expect(ParseDialect(string(dialect))).To(Equal(dialect))
+		}
+	})
+
+	It("returns an error for unknown dialects", func() {
+		_, err := ParseDialect("cucumber-cobol")
+		Expect(err).NotTo(BeNil())
+	})
+})
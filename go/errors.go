@@ -0,0 +1,49 @@
+package json
+
+import "fmt"
+
+// MissingReferenceError is returned when a message references another message
+// which has not been read, e.g. because the stream is truncated or reordered.
+type MissingReferenceError struct {
+	// Kind is the kind of the missing message, e.g. "TestCase"
+	Kind string
+	// ID is the ID (or URI) of the missing message
+	ID string
+	// Envelope is the kind of the envelope holding the reference, e.g. "TestStepFinished"
+	Envelope string
+	// Line is the line of the envelope in the NDJSON stream, 0 when unknown
+	Line int
+}
+
+func (err *MissingReferenceError) Error() string {
+	message := fmt.Sprintf("no %s for %q", err.Kind, err.ID)
+	if err.Envelope != "" {
+		message = fmt.Sprintf("%s referenced by %s", message, err.Envelope)
+	}
+	if err.Line > 0 {
+		message = fmt.Sprintf("line %d: %s", err.Line, message)
+	}
+	return message
+}
+
+// DecodeError is returned when a line of the NDJSON stream is not a valid envelope
+type DecodeError struct {
+	// Line is the line of the NDJSON stream
+	Line int
+	Err  error
+}
+
+func (err *DecodeError) Error() string {
+	return fmt.Sprintf("line %d: unable to decode envelope: %s", err.Line, err.Err)
+}
+
+func (err *DecodeError) Unwrap() error {
+	return err.Err
+}
+
+func missingReference(kind string, id string) *MissingReferenceError {
+	return &MissingReferenceError{
+		Kind: kind,
+		ID:   id,
+	}
+}
@@ -0,0 +1,98 @@
+package json
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/cucumber/common/messages/go/v18"
+)
+
+func makeMessagesStream(envelopes []*messages.Envelope) string {
+	lines := make([]string, len(envelopes))
+	for index, envelope := range envelopes {
+		line, _ := json.Marshal(envelope)
+		lines[index] = string(line)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// makeSingleScenarioRun returns the messages of a run executing a feature made of a
+// single scenario with a single step. The IDs do not depend on the parameters, so
+// two runs always use the same IDs.
+func makeSingleScenarioRun(uri string, featureName string, scenarioName string, status messages.TestStepResultStatus) []*messages.Envelope {
+	step := makeGherkinStep("step-id", "Given ", "a step")
+	step.Location = &messages.Location{Line: 3}
+	scenario := makeScenario("scenario-id", []*messages.Step{step})
+	scenario.Keyword = "Scenario"
+	scenario.Name = scenarioName
+	scenario.Location = &messages.Location{Line: 2}
+
+	pickleStep := &messages.PickleStep{
+		Id:         "pickle-step-id",
+		AstNodeIds: []string{step.Id},
+		Text:       step.Text,
+	}
+
+	return []*messages.Envelope{
+		{
+			GherkinDocument: &messages.GherkinDocument{
+				Uri: uri,
+				Feature: &messages.Feature{
+					Keyword:  "Feature",
+					Name:     featureName,
+					Location: &messages.Location{Line: 1},
+					Children: []*messages.FeatureChild{
+						{Scenario: scenario},
+					},
+				},
+			},
+		},
+		makePickleEnvelope(&messages.Pickle{
+			Id:         "pickle-id",
+			Uri:        uri,
+			Name:       scenarioName,
+			AstNodeIds: []string{scenario.Id},
+			Steps:      []*messages.PickleStep{pickleStep},
+		}),
+		makeStepDefinitionEnvelope(&messages.StepDefinition{
+			Id: "step-definition-id",
+			SourceReference: &messages.SourceReference{
+				Uri:      "steps.go",
+				Location: &messages.Location{Line: 7},
+			},
+		}),
+		makeTestCaseEnvelope(makeTestCase("test-case-id", "pickle-id", []*messages.TestStep{
+			makeTestStep("test-step-id", pickleStep.Id, []string{"step-definition-id"}),
+		})),
+		makeTestCaseStartedEnvelope(&messages.TestCaseStarted{
+			Id:         "test-case-started-id",
+			TestCaseId: "test-case-id",
+		}),
+		{
+			TestStepFinished: &messages.TestStepFinished{
+				TestCaseStartedId: "test-case-started-id",
+				TestStepId:        "test-step-id",
+				TestStepResult: &messages.TestStepResult{
+					Status:   status,
+					Duration: &messages.Duration{},
+				},
+			},
+		},
+		{
+			TestCaseFinished: &messages.TestCaseFinished{
+				TestCaseStartedId: "test-case-started-id",
+			},
+		},
+	}
+}
+
+// makeScenarioARun returns the messages of the run of "Scenario A" of
+// "Feature A", in a.feature, which most of the tests read
+func makeScenarioARun(status messages.TestStepResultStatus) []*messages.Envelope {
+	return makeSingleScenarioRun("a.feature", "Feature A", "Scenario A", status)
+}
+
+// makeScenarioAStream returns the messages of makeScenarioARun as a stream
+func makeScenarioAStream(status messages.TestStepResultStatus) string {
+	return makeMessagesStream(makeScenarioARun(status))
+}
@@ -0,0 +1,344 @@
+package json
+
+import (
+	"encoding/base64"
+	"html/template"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// htmlStatuses are the statuses of the steps, from the one which matters the
+// most to the one which matters the least: a scenario has the status of its
+// step which matters the most
+var htmlStatuses = []string{"failed", "ambiguous", "undefined", "pending", "skipped", "passed"}
+
+// htmlReporter renders the JSON report as a single HTML file, which has
+// everything it needs inline so that it can be read offline
+type htmlReporter struct {
+	report *jsonReporter
+	writer io.Writer
+}
+
+func newHTMLReporter(formatter *Formatter, writer io.Writer) (TestRunReporter, error) {
+	return &htmlReporter{
+		report: makeJSONReporter(formatter, nil),
+		writer: writer,
+	}, nil
+}
+
+func (self *htmlReporter) TestCaseFinished(testCase *TestCase) error {
+	return self.report.TestCaseFinished(testCase)
+}
+
+func (self *htmlReporter) TestRunFinished(testRun *TestRun) error {
+	jsonFeatures, err := self.report.report(testRun)
+	if err != nil {
+		return err
+	}
+	return htmlTemplate.Execute(self.writer, makeHTMLReport(jsonFeatures))
+}
+
+type htmlReport struct {
+	Features []*htmlFeature
+	Statuses []*htmlStatusCount
+	Tags     []string
+	Total    int
+}
+
+type htmlStatusCount struct {
+	Status string
+	Count  int
+}
+
+type htmlFeature struct {
+	*jsonFeature
+	Elements []*htmlElement
+}
+
+// htmlElement is a scenario, with the background which ran before it
+type htmlElement struct {
+	*jsonFeatureElement
+	Background *htmlElement
+	Status     string
+	TagNames   string
+	Steps      []*htmlStep
+	Embeddings []*htmlEmbedding
+}
+
+// htmlStep is a step or a hook, the hooks of the steps being listed around them
+type htmlStep struct {
+	*jsonStep
+	Keyword    string
+	Status     string
+	Duration   string
+	Embeddings []*htmlEmbedding
+}
+
+type htmlEmbedding struct {
+	Name     string
+	MimeType string
+	// URL is a data URL when the embedding is inline, its path otherwise. The
+	// data URL of the embeddings which are not images is a download.
+	URL   template.URL
+	Image bool
+	// Text is the content of the inline text embeddings
+	Text string
+}
+
+func makeHTMLReport(jsonFeatures []*jsonFeature) *htmlReport {
+	report := &htmlReport{
+		Features: make([]*htmlFeature, 0, len(jsonFeatures)),
+		Statuses: make([]*htmlStatusCount, 0),
+		Tags:     make([]string, 0),
+	}
+	countByStatus := make(map[string]int)
+	tagNames := make(map[string]bool)
+
+	for _, jFeature := range jsonFeatures {
+		feature := &htmlFeature{
+			jsonFeature: jFeature,
+			Elements:    make([]*htmlElement, 0, len(jFeature.Elements)),
+		}
+		report.Features = append(report.Features, feature)
+
+		var background *htmlElement
+		for _, jsonElement := range jFeature.Elements {
+			element := makeHTMLElement(jFeature, jsonElement)
+			if jsonElement.Type == "background" {
+				background = element
+				continue
+			}
+			if background != nil {
+				element.Background = background
+				element.Status = worstStatus(background.Status, element.Status)
+				background = nil
+			}
+			feature.Elements = append(feature.Elements, element)
+
+			report.Total++
+			countByStatus[element.Status]++
+			for _, tagName := range strings.Fields(element.TagNames) {
+				tagNames[tagName] = true
+			}
+		}
+	}
+
+	for _, status := range htmlStatuses {
+		if countByStatus[status] > 0 {
+			report.Statuses = append(report.Statuses, &htmlStatusCount{Status: status, Count: countByStatus[status]})
+		}
+	}
+	for tagName := range tagNames {
+		report.Tags = append(report.Tags, tagName)
+	}
+	sort.Strings(report.Tags)
+	return report
+}
+
+func makeHTMLElement(jFeature *jsonFeature, jsonElement *jsonFeatureElement) *htmlElement {
+	element := &htmlElement{
+		jsonFeatureElement: jsonElement,
+		Status:             "passed",
+		Steps:              make([]*htmlStep, 0),
+		Embeddings:         makeHTMLEmbeddings(jsonElement.Embeddings),
+	}
+
+	tagNames := make([]string, 0, len(jFeature.Tags)+len(jsonElement.Tags))
+	for _, tag := range jFeature.Tags {
+		tagNames = append(tagNames, tag.Name)
+	}
+	for _, tag := range jsonElement.Tags {
+		tagNames = append(tagNames, tag.Name)
+	}
+	element.TagNames = strings.Join(tagNames, " ")
+
+	element.appendSteps(jsonElement.Before, "Before")
+	for _, step := range jsonElement.Steps {
+		element.appendSteps(step.Before, "Before")
+		element.appendSteps([]*jsonStep{step}, "")
+		element.appendSteps(step.After, "After")
+	}
+	element.appendSteps(jsonElement.After, "After")
+	return element
+}
+
+// appendSteps adds the steps, or the hooks when hookKeyword is set
+func (self *htmlElement) appendSteps(jsonSteps []*jsonStep, hookKeyword string) {
+	for _, jStep := range jsonSteps {
+		step := &htmlStep{
+			jsonStep:   jStep,
+			Keyword:    jStep.Keyword,
+			Embeddings: makeHTMLEmbeddings(jStep.Embeddings),
+		}
+		if hookKeyword != "" && step.Keyword == "" {
+			step.Keyword = hookKeyword
+		}
+		if jStep.Result != nil {
+			step.Status = jStep.Result.Status
+			step.Duration = formatJUnitDuration(time.Duration(jStep.Result.Duration)) + "s"
+		}
+
+		self.Status = worstStatus(self.Status, step.Status)
+		self.Steps = append(self.Steps, step)
+	}
+}
+
+func makeHTMLEmbeddings(jsonEmbeddings []*jsonEmbedding) []*htmlEmbedding {
+	embeddings := make([]*htmlEmbedding, len(jsonEmbeddings))
+	for index, embedding := range jsonEmbeddings {
+		embeddings[index] = makeHTMLEmbedding(embedding)
+	}
+	return embeddings
+}
+
+// htmlImageMediaTypes are the media types of the images shown in the report.
+// The media type of the other inline embeddings is not trusted in a data URL.
+var htmlImageMediaTypes = regexp.MustCompile(`^image/(png|jpeg|gif|webp)$`)
+
+func makeHTMLEmbedding(jEmbedding *jsonEmbedding) *htmlEmbedding {
+	embedding := &htmlEmbedding{
+		Name:     jEmbedding.Name,
+		MimeType: jEmbedding.MimeType,
+		Image:    htmlImageMediaTypes.MatchString(jEmbedding.MimeType),
+	}
+	if jEmbedding.Path != "" {
+		embedding.URL = template.URL(jEmbedding.Path)
+		return embedding
+	}
+
+	if embedding.Image {
+		embedding.URL = template.URL("data:" + jEmbedding.MimeType + ";base64," + jEmbedding.Data)
+		return embedding
+	}
+	// The other embeddings are downloaded, whatever their media type
+	embedding.URL = template.URL("data:application/octet-stream;base64," + jEmbedding.Data)
+	if isText(jEmbedding.MimeType) {
+		content, err := base64.StdEncoding.DecodeString(jEmbedding.Data)
+		if err == nil {
+			embedding.Text = string(content)
+		}
+	}
+	return embedding
+}
+
+// worstStatus returns the status which matters the most
+func worstStatus(status string, other string) string {
+	for _, htmlStatus := range htmlStatuses {
+		if status == htmlStatus || other == htmlStatus {
+			return htmlStatus
+		}
+	}
+	return status
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"firstLine": firstLine,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Cucumber report</title>
+<style>
+body { font-family: sans-serif; margin: 0 2em 2em; color: #222; }
+header { position: sticky; top: 0; background: #fff; padding: 1em 0; border-bottom: 1px solid #ccc; }
+h1 { margin: 0 0 .5em; font-size: 1.5em; }
+h2 { margin: 1.5em 0 0; font-size: 1.25em; }
+h3 { margin: 0; font-size: 1em; }
+label { margin-right: 1em; }
+.uri, .duration, .location { color: #777; font-size: .85em; }
+.tag { display: inline-block; margin-right: .5em; color: #555; font-size: .85em; }
+.element { margin: 1em 0; padding: .5em 1em; border-left: 4px solid #ccc; background: #fafafa; }
+.element.passed { border-color: #3a3; }
+.element.failed, .element.ambiguous { border-color: #c33; }
+.element.undefined, .element.pending { border-color: #ca3; }
+.element.skipped { border-color: #39c; }
+.steps { list-style: none; margin: .5em 0 0; padding: 0; }
+.step { margin: .25em 0; }
+.step .keyword { font-weight: bold; }
+.status { display: inline-block; min-width: 5.5em; padding: 0 .25em; border-radius: 3px; color: #fff; background: #999; font-size: .8em; text-align: center; }
+.status.passed { background: #3a3; }
+.status.failed, .status.ambiguous { background: #c33; }
+.status.undefined, .status.pending { background: #ca3; }
+.status.skipped { background: #39c; }
+.background { margin-bottom: .5em; color: #555; }
+pre { margin: .25em 0 .25em 6em; padding: .5em; background: #eee; overflow-x: auto; }
+table { margin: .25em 0 .25em 6em; border-collapse: collapse; }
+td { padding: .1em .5em; border: 1px solid #ccc; }
+details { margin-left: 6em; }
+details pre { margin-left: 0; color: #c33; }
+summary { cursor: pointer; color: #c33; }
+.embedding { margin-left: 6em; }
+.embedding img { max-width: 100%; border: 1px solid #ccc; }
+</style>
+</head>
+<body>
+<header>
+<h1>Cucumber report</h1>
+<p class="summary">{{.Total}} scenarios{{range .Statuses}} <span class="status {{.Status}}">{{.Count}} {{.Status}}</span>{{end}}</p>
+<form id="filters">
+{{range .Statuses}}<label><input type="checkbox" name="status" value="{{.Status}}" checked> {{.Status}}</label>
+{{end}}<label>Tag <select name="tag"><option value="">all</option>{{range .Tags}}<option>{{.}}</option>{{end}}</select></label>
+</form>
+</header>
+<main>
+{{range .Features}}<section class="feature">
+<h2>{{.Keyword}}: {{.Name}}</h2>
+<p class="uri">{{.URI}}</p>
+{{range .Tags}}<span class="tag">{{.Name}}</span>{{end}}
+{{range .Elements}}<article class="element {{.Status}}" data-status="{{.Status}}" data-tags="{{.TagNames}}">
+<h3><span class="status {{.Status}}">{{.Status}}</span> {{.Keyword}}: {{.Name}}</h3>
+{{range .Tags}}<span class="tag">{{.Name}}</span>{{end}}
+{{with .Background}}<div class="background">
+<h3>{{.Keyword}}: {{.Name}}</h3>
+{{template "steps" .Steps}}
+</div>
+{{end}}{{template "steps" .Steps}}
+{{template "embeddings" .Embeddings}}{{range .Output}}<pre class="output">{{.}}</pre>
+{{end}}</article>
+{{end}}</section>
+{{end}}</main>
+<script>
+(function () {
+  var form = document.getElementById('filters');
+  function forEach(elements, f) {
+    Array.prototype.forEach.call(elements, f);
+  }
+  function filter() {
+    var statuses = {};
+    forEach(form.querySelectorAll('input[name=status]'), function (input) {
+      statuses[input.value] = input.checked;
+    });
+    var tag = form.elements.tag.value;
+    forEach(document.querySelectorAll('.feature'), function (feature) {
+      var visible = 0;
+      forEach(feature.querySelectorAll('.element'), function (element) {
+        var tags = ' ' + element.getAttribute('data-tags') + ' ';
+        element.hidden = !statuses[element.getAttribute('data-status')] || (tag !== '' && tags.indexOf(' ' + tag + ' ') < 0);
+        if (!element.hidden) {
+          visible++;
+        }
+      });
+      feature.hidden = visible === 0;
+    });
+  }
+  form.addEventListener('change', filter);
+})();
+</script>
+</body>
+</html>
+{{define "steps"}}<ol class="steps">
+{{range .}}<li class="step {{.Status}}">
+<span class="status {{.Status}}">{{.Status}}</span> <span class="keyword">{{.Keyword}}</span>{{.Name}} <span class="duration">{{.Duration}}</span>{{with .Match}} <span class="location">{{.Location}}</span>{{end}}
+{{with .DocString}}<pre class="doc-string">{{.Value}}</pre>
+{{end}}{{with .Rows}}<table class="data-table">{{range .}}<tr>{{range .Cells}}<td>{{.}}</td>{{end}}</tr>{{end}}</table>
+{{end}}{{range .Arguments}}{{with .Content}}<pre class="doc-string">{{.}}</pre>
+{{end}}{{with .Rows}}<table class="data-table">{{range .}}<tr>{{range .Cells}}<td>{{.}}</td>{{end}}</tr>{{end}}</table>
+{{end}}{{end}}{{with .Result}}{{with .ErrorMessage}}<details class="error"><summary>{{firstLine .}}</summary><pre>{{.}}</pre></details>
+{{end}}{{end}}{{template "embeddings" .Embeddings}}{{range .Output}}<pre class="output">{{.}}</pre>
+{{end}}</li>
+{{end}}</ol>{{end}}
+{{define "embeddings"}}{{range .}}<div class="embedding">{{if .Image}}<img src="{{.URL}}" alt="{{.Name}}">{{else if .Text}}<pre>{{.Text}}</pre>{{else}}<a href="{{.URL}}" download>{{if .Name}}{{.Name}}{{else}}{{.MimeType}}{{end}}</a>{{end}}</div>
+{{end}}{{end}}`))
@@ -0,0 +1,144 @@
+package json
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+
+	"github.com/cucumber/common/messages/go/v18"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("htmlReporter", func() {
+	var (
+		formatter *Formatter
+		output    *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		formatter = &Formatter{}
+		output = &bytes.Buffer{}
+		reporter, err := NewReporter("html", formatter, output)
+		Expect(err).To(BeNil())
+		formatter.AddReporter(reporter)
+	})
+
+	report := func(envelopes []*messages.Envelope) string {
+		Expect(formatter.ReadMessages(strings.NewReader(makeMessagesStream(envelopes)))).To(Succeed())
+		Expect(formatter.EndReport()).To(Succeed())
+		return output.String()
+	}
+
+	It("writes a single HTML document with the scenarios", func() {
+		html := report(makeScenarioARun(messages.TestStepResultStatus_PASSED))
+
+		Expect(html).To(HavePrefix("<!DOCTYPE html>"))
+		Expect(html).To(ContainSubstring("<h2>Feature: Feature A</h2>"))
+		Expect(html).To(ContainSubstring(`<article class="element passed" data-status="passed"`))
+		Expect(html).To(ContainSubstring(`<span class="keyword">Given </span>a step`))
+		Expect(html).To(ContainSubstring(`<input type="checkbox" name="status" value="passed" checked>`))
+		Expect(html).NotTo(ContainSubstring("<script src"))
+		Expect(html).NotTo(ContainSubstring("<link"))
+	})
+
+	It("expands the error messages", func() {
+		envelopes := makeScenarioARun(messages.TestStepResultStatus_FAILED)
+		envelopes[5].TestStepFinished.TestStepResult.Message = "boom\n  at steps.go:7"
+
+		html := report(envelopes)
+
+		Expect(html).To(ContainSubstring(`<details class="error"><summary>boom</summary><pre>boom
+  at steps.go:7</pre></details>`))
+		Expect(html).To(ContainSubstring(`data-status="failed"`))
+	})
+
+	It("inlines the images", func() {
+		envelopes := makeScenarioARun(messages.TestStepResultStatus_PASSED)
+		data := base64.StdEncoding.EncodeToString([]byte("some image"))
+		attachment := &messages.Envelope{Attachment: &messages.Attachment{
+			TestCaseStartedId: "test-case-started-id",
+			TestStepId:        "test-step-id",
+			Body:              data,
+			ContentEncoding:   messages.AttachmentContentEncoding_BASE64,
+			MediaType:         "image/png",
+		}}
+		envelopes = append(envelopes[:5], attachment, envelopes[5], envelopes[6])
+
+		html := report(envelopes)
+
+		Expect(html).To(ContainSubstring(`<img src="data:image/png;base64,` + data + `"`))
+	})
+
+	It("downloads the embeddings which are not images", func() {
+		envelopes := makeScenarioARun(messages.TestStepResultStatus_PASSED)
+		data := base64.StdEncoding.EncodeToString([]byte("<script>alert(1)</script>"))
+		attachment := &messages.Envelope{Attachment: &messages.Attachment{
+			TestCaseStartedId: "test-case-started-id",
+			TestStepId:        "test-step-id",
+			Body:              data,
+			ContentEncoding:   messages.AttachmentContentEncoding_BASE64,
+			MediaType:         "image/svg+xml",
+		}}
+		envelopes = append(envelopes[:5], attachment, envelopes[5], envelopes[6])
+
+		html := report(envelopes)
+
+		Expect(html).NotTo(ContainSubstring("<img"))
+		Expect(html).NotTo(ContainSubstring("data:image/svg+xml"))
+		Expect(html).To(ContainSubstring(`<a href="data:application/octet-stream;base64,` + data + `" download>image/svg&#43;xml</a>`))
+	})
+
+	It("escapes the content of the messages", func() {
+		html := report(makeSingleScenarioRun("a.feature", "Feature A", "<script>alert(1)</script>", messages.TestStepResultStatus_PASSED))
+
+		Expect(html).NotTo(ContainSubstring("<script>alert(1)</script>"))
+		Expect(html).To(ContainSubstring("&lt;script&gt;alert(1)&lt;/script&gt;"))
+	})
+})
+
+var _ = Describe("makeHTMLReport", func() {
+	It("groups the backgrounds with the scenarios which follow them", func() {
+		background := &jsonFeatureElement{
+			Type:  "background",
+			Steps: []*jsonStep{{Name: "a background step", Result: &jsonStepResult{Status: "failed"}}},
+		}
+		scenario := &jsonFeatureElement{
+			Type:  "scenario",
+			Tags:  []*jsonTag{{Name: "@wip"}},
+			Steps: []*jsonStep{{Name: "a step", Result: &jsonStepResult{Status: "skipped"}}},
+		}
+
+		report := makeHTMLReport([]*jsonFeature{{
+			Tags:     []*jsonTag{{Name: "@feature"}},
+			Elements: []*jsonFeatureElement{background, scenario},
+		}})
+
+		Expect(report.Total).To(Equal(1))
+		element := report.Features[0].Elements[0]
+		Expect(element.Background.jsonFeatureElement).To(Equal(background))
+		Expect(element.Status).To(Equal("failed"))
+		Expect(element.TagNames).To(Equal("@feature @wip"))
+		Expect(report.Tags).To(Equal([]string{"@feature", "@wip"}))
+	})
+
+	It("lists the hooks around the steps", func() {
+		step := &jsonStep{
+			Name:   "a step",
+			Result: &jsonStepResult{Status: "passed"},
+			Before: []*jsonStep{{Result: &jsonStepResult{Status: "passed"}}},
+			After:  []*jsonStep{{Result: &jsonStepResult{Status: "pending"}}},
+		}
+
+		report := makeHTMLReport([]*jsonFeature{{
+			Elements: []*jsonFeatureElement{{Type: "scenario", Steps: []*jsonStep{step}}},
+		}})
+
+		steps := report.Features[0].Elements[0].Steps
+		Expect(len(steps)).To(Equal(3))
+		Expect(steps[0].Keyword).To(Equal("Before"))
+		Expect(steps[1].Name).To(Equal("a step"))
+		Expect(steps[2].Keyword).To(Equal("After"))
+		Expect(report.Features[0].Elements[0].Status).To(Equal("pending"))
+	})
+})
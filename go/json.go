@@ -1,118 +1,643 @@
 package json
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"reflect"
 	"strings"
+	"time"
 
+	"github.com/cucumber/common/json-formatter/go/v19/model"
 	"github.com/cucumber/common/messages/go/v18"
 )
 
+// RetryMode tells how the attempts of retried test cases are reported
+type RetryMode string
+
+const (
+	// RetryModeAll reports every attempt as a separate element, as cucumber-ruby does
+	RetryModeAll RetryMode = "all"
+	// RetryModeLast only reports the final attempt of each test case
+	RetryModeLast RetryMode = "last"
+	// RetryModeAnnotated reports every attempt, each one carrying its attempt number
+	RetryModeAnnotated RetryMode = "annotated"
+)
+
+// ParseRetryMode returns the RetryMode named s
+func ParseRetryMode(s string) (RetryMode, error) {
+	switch mode := RetryMode(s); mode {
+	case RetryModeAll, RetryModeLast, RetryModeAnnotated:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown retry mode %q (expected one of: all, last, annotated)", s)
+}
+
+// TestRunFeatureID is the ID of the feature reporting the attachments which are
+// not attached to a test case, such as those of the hooks running once per run
+const TestRunFeatureID = "test-run"
+
+// ParseErrorName is the name of the elements reporting the errors of the
+// feature files which could not be parsed
+const ParseErrorName = "Parse error"
+
+// InterruptedMessage is the error message of the test cases which started but
+// never finished, in lenient mode
+const InterruptedMessage = "interrupted: the test case did not finish"
+
 type Formatter struct {
+	// RetryMode defaults to RetryModeAll
+	RetryMode RetryMode
+	// Lenient makes a best-effort report out of a broken stream: the envelopes
+	// which can not be decoded or resolved are skipped, and the test cases
+	// which never finished are reported as failed. What went wrong is
+	// available in Diagnostics.
+	Lenient bool
+	// Logger receives the verbose diagnostics: the lookup hits and misses, and the
+	// processing of each envelope with its timing. Nothing is logged when nil.
+	Logger *log.Logger
+	// AttachmentsDir is where the embeddings are written to, in files named
+	// after their content, instead of being inlined in base64. Their path is
+	// relative to ReportDir.
+	AttachmentsDir string
+	// ReportDir is the directory of the reports referencing the files of
+	// AttachmentsDir, the current directory when empty
+	ReportDir string
+	// InlineAttachmentMaxSize is the size, in bytes, up to which the text
+	// embeddings stay inline when AttachmentsDir is set
+	InlineAttachmentMaxSize int
+	// StreamJSON makes the reporters of the json format write each feature
+	// element as soon as its test case finished
+	StreamJSON bool
+	// RerunStatuses are the statuses of the scenarios listed by the reporters
+	// of the rerun format, DefaultRerunStatuses when empty
+	RerunStatuses []messages.TestStepResultStatus
+	Options
+
 	lookup *MessageLookup
 
-	jsonFeatures      []*jsonFeature
-	jsonFeaturesByURI map[string]*jsonFeature
-	testCaseById      map[string]*TestCase
-	verbose           bool
+	report              *jsonReporter
+	testCases           []*TestCase
+	testCaseByStartedId map[string]*TestCase
+	startedTestCaseIds  []string
+	jsonStream          *jsonReporter
+	reporters           []TestRunReporter
+	testRunStarted      *messages.TestRunStarted
+	testRunFinished     *messages.TestRunFinished
+	statusCounts        map[messages.TestStepResultStatus]int
+	parseErrors         []*messages.ParseError
+	diagnostics         []error
+	streamCount         int
+	verbose             bool
 }
 
-// ProcessMessages writes a JSON report to STDOUT
+// ProcessMessages writes a JSON report to STDOUT of a single stream of
+// messages. It starts a new report on every call: ReadMessages, then WriteJSON,
+// merge the results of several streams in a single report.
 func (self *Formatter) ProcessMessages(reader io.Reader, stdout io.Writer) (err error) {
-	self.verbose = false
-	self.lookup = &MessageLookup{}
-	self.lookup.Initialize(self.verbose)
+	self.initialize()
+
+	err = self.ReadMessages(reader)
+	if err != nil {
+		return err
+	}
+	return self.WriteJSON(stdout)
+}
+
+// StreamMessages writes a JSON report to STDOUT while reading the messages,
+// keeping the memory use flat regardless of the size of the run
+func (self *Formatter) StreamMessages(reader io.Reader, stdout io.Writer) (err error) {
+	self.initialize()
+
+	err = self.StartJSONStream(stdout)
+	if err != nil {
+		return err
+	}
+	err = self.ReadMessages(reader)
+	if err != nil {
+		return err
+	}
+	return self.EndJSONStream()
+}
 
-	self.jsonFeatures = make([]*jsonFeature, 0)
-	self.jsonFeaturesByURI = make(map[string]*jsonFeature)
-	self.testCaseById = make(map[string]*TestCase)
+// StartJSONStream makes ReadMessages write each feature element to writer as
+// soon as its test case finished, instead of keeping the report in memory until
+// WriteJSON. The messages are forgotten once written, so WriteJSON and
+// WriteJUnit do not report them. EndJSONStream completes the report.
+func (self *Formatter) StartJSONStream(writer io.Writer) error {
+	if self.lookup == nil {
+		self.initialize()
+	}
+	jsonStream, err := makeJSONStreamReporter(self, writer)
+	if err != nil {
+		return err
+	}
+	self.jsonStream = jsonStream
+	return nil
+}
+
+// EndJSONStream completes the JSON report started by StartJSONStream
+func (self *Formatter) EndJSONStream() error {
+	if self.jsonStream == nil {
+		return errors.New("no JSON stream was started")
+	}
+	jsonStream := self.jsonStream
+	self.jsonStream = nil
+	return jsonStream.TestRunFinished(self.makeTestRun())
+}
+
+// AddReporter hands the test cases read from now on to reporter. Once a
+// reporter is added, the test cases are no longer kept for Report, WriteJSON
+// and WriteJUnit: only the reporters which need the whole run keep it in memory.
+func (self *Formatter) AddReporter(reporter TestRunReporter) {
+	self.reporters = append(self.reporters, reporter)
+}
+
+// EndReport hands the run-level results to the reporters, which complete their
+// output, once every message was read
+func (self *Formatter) EndReport() error {
+	if self.lookup == nil {
+		self.initialize()
+	}
 
-	decoder := json.NewDecoder(reader)
+	testRun := self.makeTestRun()
+	for _, reporter := range self.reporters {
+		err := reporter.TestRunFinished(testRun)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadMessages adds the messages of one NDJSON stream to the report.
+//
+// It can be called once per stream to merge the results of several runs
+// (e.g. sharded executions) into a single report: features sharing the same
+// URI are merged, and the IDs of each stream are kept apart so they may collide.
+func (self *Formatter) ReadMessages(reader io.Reader) (err error) {
+	self.startStream()
+
+	self.comment(fmt.Sprintf("Reading stream #%d", self.streamCount))
+	start := time.Now()
+	bufferedReader := bufio.NewReader(reader)
+	line := 0
 	for {
-		envelope := &messages.Envelope{}
-		err := decoder.Decode(envelope)
-		if err == io.EOF {
-			break
+		data, readErr := bufferedReader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		line++
+
+		if len(bytes.TrimSpace(data)) > 0 {
+			err = self.processLine(data, line)
+			if err != nil && !self.Lenient {
+				return err
+			}
+			if err != nil {
+				self.diagnostics = append(self.diagnostics, err)
+			}
+		}
+
+		if readErr == io.EOF {
+			if self.Lenient {
+				self.interruptTestCases()
+			}
+			self.comment(fmt.Sprintf("Read stream #%d (%d lines) in %s", self.streamCount, line, time.Since(start)))
+			return nil
+		}
+	}
+}
+
+// Handle adds one envelope to the report, for the messages which are produced
+// in process rather than read from NDJSON. The envelopes belong to the stream
+// last read by ReadMessages, or to a stream of their own when none was read.
+// The report is then available from Report, WriteJSON or WriteJUnit.
+//
+// A Formatter is not safe for concurrent use, but independent Formatters are.
+func (self *Formatter) Handle(envelope *messages.Envelope) error {
+	if self.streamCount == 0 {
+		self.startStream()
+	}
+	return self.processEnvelope(envelope)
+}
+
+// startStream keeps the IDs of the messages handled from now on apart from the
+// ones of the previous streams
+func (self *Formatter) startStream() {
+	if self.lookup == nil {
+		self.initialize()
+	}
+	self.streamCount++
+	self.lookup.SetScope(fmt.Sprintf("%d:", self.streamCount))
+	self.testCaseByStartedId = make(map[string]*TestCase)
+	self.startedTestCaseIds = make([]string, 0)
+}
+
+// Diagnostics lists what went wrong while reading the messages in lenient mode,
+// and the embeddings which could not be written to AttachmentsDir
+func (self *Formatter) Diagnostics() []error {
+	return self.diagnostics
+}
+
+func (self *Formatter) processLine(data []byte, line int) error {
+	envelope := &messages.Envelope{}
+	err := json.Unmarshal(data, envelope)
+	if err != nil {
+		return &DecodeError{Line: line, Err: err}
+	}
+
+	start := time.Now()
+	err = self.Handle(envelope)
+	self.comment(fmt.Sprintf("Processed %s (line %d) in %s", envelopeKind(envelope), line, time.Since(start)))
+	if err != nil {
+		self.comment(fmt.Sprintf("Error: %s", err))
+		var missingReferenceError *MissingReferenceError
+		if errors.As(err, &missingReferenceError) {
+			missingReferenceError.Envelope = envelopeKind(envelope)
+			missingReferenceError.Line = line
 		}
+		return err
+	}
+	return nil
+}
+
+func (self *Formatter) processEnvelope(envelope *messages.Envelope) error {
+	err := self.lookup.ProcessMessage(envelope)
+	if err != nil {
+		return err
+	}
+
+	if envelope.ParseError != nil {
+		self.parseErrors = append(self.parseErrors, envelope.ParseError)
+	}
+
+	if envelope.TestRunStarted != nil && self.testRunStarted == nil {
+		self.testRunStarted = envelope.TestRunStarted
+	}
+
+	if envelope.TestRunFinished != nil {
+		testRunFinished := *envelope.TestRunFinished
+		if self.testRunFinished != nil && !self.testRunFinished.Success {
+			testRunFinished.Success = false
+		}
+		self.testRunFinished = &testRunFinished
+	}
+
+	if envelope.TestCaseStarted != nil {
+		err, testCase := ProcessTestCaseStarted(envelope.TestCaseStarted, self.lookup)
 		if err != nil {
 			return err
 		}
+		self.testCaseByStartedId[envelope.TestCaseStarted.Id] = testCase
+		self.startedTestCaseIds = append(self.startedTestCaseIds, envelope.TestCaseStarted.Id)
+	}
 
-		err = self.lookup.ProcessMessage(envelope)
+	if envelope.TestStepFinished != nil {
+		err, testStep := ProcessTestStepFinished(envelope.TestStepFinished, self.lookup)
 		if err != nil {
 			return err
 		}
+		testCase, ok := self.testCaseByStartedId[testStep.TestCaseStartedID]
+		if !ok {
+			return missingReference("TestCaseStarted", testStep.TestCaseStartedID)
+		}
+		testCase.appendStep(testStep)
+	}
+
+	if envelope.TestCaseFinished != nil {
+		testCase, ok := self.testCaseByStartedId[envelope.TestCaseFinished.TestCaseStartedId]
+		if !ok {
+			return missingReference("TestCaseStarted", envelope.TestCaseFinished.TestCaseStartedId)
+		}
 
-		if envelope.TestCaseStarted != nil {
-			err, testCase := ProcessTestCaseStarted(envelope.TestCaseStarted, self.lookup)
-			if err != nil {
-				return err
+		delete(self.testCaseByStartedId, envelope.TestCaseFinished.TestCaseStartedId)
+		// Only the test cases which did not finish are kept, for interruptTestCases
+		for index, testCaseStartedId := range self.startedTestCaseIds {
+			if testCaseStartedId == envelope.TestCaseFinished.TestCaseStartedId {
+				self.startedTestCaseIds = append(self.startedTestCaseIds[:index], self.startedTestCaseIds[index+1:]...)
+				break
 			}
-			self.testCaseById[testCase.TestCase.Id] = testCase
 		}
+		testCase.Attachments = self.lookup.LookupTestCaseAttachments(envelope.TestCaseFinished.TestCaseStartedId)
+		self.lookup.EvictTestCaseStarted(envelope.TestCaseFinished)
+		testCase.WillBeRetried = envelope.TestCaseFinished.WillBeRetried
+		if !testCase.WillBeRetried {
+			self.statusCounts[testCase.Status()]++
+		}
+		if self.isReported(envelope.TestCaseFinished) {
+			return self.appendTestCase(testCase)
+		}
+	}
+
+	return nil
+}
+
+func (self *Formatter) appendTestCase(testCase *TestCase) error {
+	for _, reporter := range self.reporters {
+		err := reporter.TestCaseFinished(testCase)
+		if err != nil {
+			return err
+		}
+	}
+
+	if self.jsonStream != nil {
+		return self.jsonStream.TestCaseFinished(testCase)
+	}
+	if len(self.reporters) > 0 {
+		return nil
+	}
+
+	self.testCases = append(self.testCases, testCase)
+	return self.report.TestCaseFinished(testCase)
+}
+
+// makeJsonElements renders a test case with the settings of the Formatter
+func (self *Formatter) makeJsonElements(testCase *TestCase) ([]*jsonFeatureElement, error) {
+	jsonElements := TestCaseToJSON(testCase, self.Options)
+	if self.RetryMode == RetryModeAnnotated {
+		for _, jsonElement := range jsonElements {
+			jsonElement.Attempt = testCase.Attempt + 1
+		}
+	}
+	return jsonElements, self.externalizeEmbeddings(jsonElements)
+}
+
+// interruptTestCases reports the test cases which started but never finished.
+// The first step which did not finish is failed, and the next ones are skipped.
+func (self *Formatter) interruptTestCases() {
+	for _, testCaseStartedId := range self.startedTestCaseIds {
+		testCase, ok := self.testCaseByStartedId[testCaseStartedId]
+		if !ok {
+			continue
+		}
+		delete(self.testCaseByStartedId, testCaseStartedId)
 
-		if envelope.TestStepFinished != nil {
-			err, testStep := ProcessTestStepFinished(envelope.TestStepFinished, self.lookup)
+		remainingTestSteps := make([]*messages.TestStep, 0)
+		if len(testCase.Steps) < len(testCase.TestCase.TestSteps) {
+			remainingTestSteps = testCase.TestCase.TestSteps[len(testCase.Steps):]
+		}
+
+		status := messages.TestStepResultStatus_FAILED
+		message := InterruptedMessage
+		for _, testStep := range remainingTestSteps {
+			err, step := ProcessTestStepFinished(&messages.TestStepFinished{
+				TestCaseStartedId: testCaseStartedId,
+				TestStepId:        testStep.Id,
+				TestStepResult: &messages.TestStepResult{
+					Status:   status,
+					Message:  message,
+					Duration: &messages.Duration{},
+				},
+			}, self.lookup)
 			if err != nil {
-				return err
+				self.diagnostics = append(self.diagnostics, err)
+				continue
 			}
-			testCase := self.testCaseById[testStep.TestCaseID]
-			if testCase == nil {
-				keys := make([]string, 0, len(self.testCaseById))
-				for k := range self.testCaseById {
-					keys = append(keys, k)
+			testCase.appendStep(step)
+			status = messages.TestStepResultStatus_SKIPPED
+			message = ""
+		}
+
+		// Every step finished: the test case was interrupted right before finishing
+		if status == messages.TestStepResultStatus_FAILED && len(testCase.Steps) > 0 {
+			lastStep := testCase.Steps[len(testCase.Steps)-1]
+			if lastStep.Result.Status != messages.TestStepResultStatus_FAILED {
+				lastStep.Result = &messages.TestStepResult{
+					Status:   messages.TestStepResultStatus_FAILED,
+					Message:  InterruptedMessage,
+					Duration: lastStep.Result.Duration,
 				}
-				panic("No testCase for " + testStep.TestCaseID + strings.Join(keys, ", "))
 			}
-			testCase.appendStep(testStep)
 		}
 
-		if envelope.TestCaseFinished != nil {
-			testCaseStarted := self.lookup.LookupTestCaseStarted(envelope.TestCaseFinished.TestCaseStartedId)
-			testCase, ok := self.testCaseById[testCaseStarted.TestCaseId]
+		testCase.Attachments = self.lookup.LookupTestCaseAttachments(testCaseStartedId)
+		self.statusCounts[testCase.Status()]++
+		self.diagnostics = append(self.diagnostics, fmt.Errorf("test case %q was interrupted", testCase.Pickle.Name))
+		err := self.appendTestCase(testCase)
+		if err != nil {
+			self.diagnostics = append(self.diagnostics, err)
+		}
+	}
+}
 
-			if ok {
-				jsonFeature := self.findOrCreateJsonFeature(testCase.Pickle)
-				for _, jsonElement := range TestCaseToJSON(testCase) {
-					jsonFeature.Elements = append(jsonFeature.Elements, jsonElement)
-				}
-			}
+// Report returns the JSON report of all the messages read so far, before it is
+// serialised. The features are shared with the Formatter: they are updated as
+// more messages are read. The test cases written to a JSON stream are not part
+// of the report.
+func (self *Formatter) Report() ([]*model.Feature, error) {
+	if self.lookup == nil {
+		self.initialize()
+	}
+
+	return self.report.report(self.makeTestRun())
+}
+
+// WriteJSON writes the JSON report of all the messages read so far
+func (self *Formatter) WriteJSON(writer io.Writer) error {
+	if self.lookup == nil {
+		self.initialize()
+	}
+
+	jsonFeatures, err := self.Report()
+	if err != nil {
+		return err
+	}
+	return writeJSON(writer, jsonFeatures)
+}
+
+// Success tells whether the test run read so far succeeded: as its
+// TestRunFinished message tells when there is one, otherwise when no feature
+// file failed to parse and the last attempt of no test case failed. In strict
+// mode, the test cases which are pending or undefined make it fail as well.
+func (self *Formatter) Success(strict bool) bool {
+	if self.lookup == nil {
+		self.initialize()
+	}
+
+	failedStatuses := []messages.TestStepResultStatus{
+		messages.TestStepResultStatus_FAILED,
+		messages.TestStepResultStatus_AMBIGUOUS,
+	}
+	if self.testRunFinished != nil {
+		if !self.testRunFinished.Success {
+			return false
+		}
+		failedStatuses = nil
+	} else if len(self.parseErrors) > 0 {
+		return false
+	}
+	if strict {
+		failedStatuses = append(
+			failedStatuses,
+			messages.TestStepResultStatus_PENDING,
+			messages.TestStepResultStatus_UNDEFINED,
+			messages.TestStepResultStatus_UNKNOWN,
+		)
+	}
+
+	for _, status := range failedStatuses {
+		if self.statusCounts[status] > 0 {
+			return false
 		}
 	}
+	return true
+}
+
+func (self *Formatter) isReported(testCaseFinished *messages.TestCaseFinished) bool {
+	return self.RetryMode != RetryModeLast || !testCaseFinished.WillBeRetried
+}
+
+func (self *Formatter) initialize() {
+	self.verbose = self.Logger != nil
+	self.lookup = &MessageLookup{}
+	self.lookup.Initialize(self.verbose)
+	if self.verbose {
+		self.lookup.SetLogger(self.Logger)
+	}
 
-	output, _ := json.MarshalIndent(self.jsonFeatures, "", "  ")
-	_, err = fmt.Fprintln(stdout, string(output))
-	return err
+	self.report = makeJSONReporter(self, nil)
+	self.testCases = make([]*TestCase, 0)
+	self.testCaseByStartedId = make(map[string]*TestCase)
+	self.startedTestCaseIds = make([]string, 0)
+	self.jsonStream = nil
+	self.testRunStarted = nil
+	self.testRunFinished = nil
+	self.statusCounts = make(map[messages.TestStepResultStatus]int)
+	self.parseErrors = make([]*messages.ParseError, 0)
+	self.diagnostics = make([]error, 0)
+	self.streamCount = 0
 }
 
-func (self *Formatter) findOrCreateJsonFeature(pickle *messages.Pickle) *jsonFeature {
-	jFeature, ok := self.jsonFeaturesByURI[pickle.Uri]
-	if !ok {
-		gherkinDocumentFeature := self.lookup.LookupGherkinDocument(pickle.Uri).Feature
+func (self *Formatter) makeJsonFeature(pickle *messages.Pickle) *jsonFeature {
+	gherkinDocumentFeature := self.lookup.LookupGherkinDocument(pickle.Uri).Feature
 
-		jFeature = &jsonFeature{
-			Description: gherkinDocumentFeature.Description,
-			Elements:    make([]*jsonFeatureElement, 0),
-			ID:          self.makeId(gherkinDocumentFeature.Name),
-			Keyword:     gherkinDocumentFeature.Keyword,
-			Line:        uint32(gherkinDocumentFeature.Location.Line),
-			Name:        gherkinDocumentFeature.Name,
-			URI:         pickle.Uri,
-			Tags:        make([]*jsonTag, len(gherkinDocumentFeature.Tags)),
+	jFeature := &jsonFeature{
+		Description: gherkinDocumentFeature.Description,
+		Elements:    make([]*jsonFeatureElement, 0),
+		ID:          self.makeId(gherkinDocumentFeature.Name),
+		Keyword:     gherkinDocumentFeature.Keyword,
+		Line:        uint32(gherkinDocumentFeature.Location.Line),
+		Name:        gherkinDocumentFeature.Name,
+		URI:         pickle.Uri,
+		Tags:        make([]*jsonTag, len(gherkinDocumentFeature.Tags)),
+	}
+
+	for tagIndex, tag := range gherkinDocumentFeature.Tags {
+		jFeature.Tags[tagIndex] = &jsonTag{
+			Line: uint32(tag.Location.Line),
+			Name: tag.Name,
 		}
+	}
+	return jFeature
+}
 
-		for tagIndex, tag := range gherkinDocumentFeature.Tags {
-			jFeature.Tags[tagIndex] = &jsonTag{
-				Line: uint32(tag.Location.Line),
-				Name: tag.Name,
+// makeTestRunJsonFeature reports the attachments which are not attached to a
+// test case in a feature of their own, with no URI
+func (self *Formatter) makeTestRunJsonFeature(attachments []*messages.Attachment) (*jsonFeature, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+
+	jFeature := &jsonFeature{
+		ID:   TestRunFeatureID,
+		Name: "Test run",
+		Elements: []*jsonFeatureElement{
+			{
+				ID:         TestRunFeatureID,
+				Name:       "Attachments",
+				Type:       "test_run",
+				Steps:      make([]*jsonStep, 0),
+				Embeddings: makeEmbeddings(attachments),
+				Output:     makeOutput(attachments),
+			},
+		},
+	}
+	return jFeature, self.externalizeEmbeddings(jFeature.Elements)
+}
+
+// makeParseErrorJsonFeatures reports the feature files which could not be
+// parsed in features of their own, with an element failing in a Before hook
+// for each parse error
+func makeParseErrorJsonFeatures(parseErrors []*messages.ParseError) []*jsonFeature {
+	jsonFeatures := make([]*jsonFeature, 0)
+	jsonFeaturesByURI := make(map[string]*jsonFeature)
+
+	for _, parseError := range parseErrors {
+		uri, line := parseErrorLocation(parseError)
+		jFeature, ok := jsonFeaturesByURI[uri]
+		if !ok {
+			// The parse errors without a source are told apart from the
+			// feature of the test run, which has no URI either
+			name := uri
+			if name == "" {
+				name = ParseErrorName
+			}
+			jFeature = &jsonFeature{
+				Elements: make([]*jsonFeatureElement, 0),
+				ID:       makeID(name),
+				Keyword:  "Feature",
+				Line:     1,
+				Name:     name,
+				URI:      uri,
 			}
+			jsonFeaturesByURI[uri] = jFeature
+			jsonFeatures = append(jsonFeatures, jFeature)
 		}
 
-		self.jsonFeaturesByURI[pickle.Uri] = jFeature
-		self.jsonFeatures = append(self.jsonFeatures, jFeature)
+		jFeature.Elements = append(jFeature.Elements, &jsonFeatureElement{
+			ID:      fmt.Sprintf("%s;%s;%d", jFeature.ID, makeID(ParseErrorName), line),
+			Keyword: "Scenario",
+			Line:    uint32(line),
+			Name:    ParseErrorName,
+			Type:    "scenario",
+			Before: []*jsonStep{
+				{
+					Match: &jsonStepMatch{Location: makeLocation(uri, line)},
+					Result: &jsonStepResult{
+						Status:       makeStatus(messages.TestStepResultStatus_FAILED),
+						ErrorMessage: parseError.Message,
+					},
+				},
+			},
+			Steps: make([]*jsonStep, 0),
+		})
 	}
-	return jFeature
+	return jsonFeatures
+}
+
+func parseErrorLocation(parseError *messages.ParseError) (string, int64) {
+	if parseError.Source == nil {
+		return "", 0
+	}
+	if parseError.Source.Location == nil {
+		return parseError.Source.Uri, 0
+	}
+	return parseError.Source.Uri, parseError.Source.Location.Line
+}
+
+func (self *Formatter) makeTestRun() *TestRun {
+	return &TestRun{
+		TestRunStarted:  self.testRunStarted,
+		TestRunFinished: self.testRunFinished,
+		Attachments:     self.lookup.LookupRunAttachments(),
+		ParseErrors:     self.parseErrors,
+	}
+}
+
+// envelopeKind returns the name of the message held by the envelope
+func envelopeKind(envelope *messages.Envelope) string {
+	value := reflect.ValueOf(envelope).Elem()
+	for index := 0; index < value.NumField(); index++ {
+		if !value.Field(index).IsNil() {
+			return value.Type().Field(index).Name
+		}
+	}
+	return "Envelope"
 }
 
 func (self *Formatter) makeId(s string) string {
@@ -121,6 +646,6 @@ func (self *Formatter) makeId(s string) string {
 
 func (self *Formatter) comment(message string) {
 	if self.verbose {
-		fmt.Println(fmt.Sprintf("// Formatter: %s", message))
+		self.Logger.Printf("// Formatter: %s", message)
 	}
 }
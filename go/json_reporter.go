@@ -0,0 +1,134 @@
+package json
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// jsonReporter renders the test cases as a JSON report: in one go once the run
+// finished, or one feature element at a time when streaming
+type jsonReporter struct {
+	formatter *Formatter
+	writer    io.Writer
+	stream    *jsonStreamWriter
+
+	jsonFeatures      []*jsonFeature
+	jsonFeaturesByURI map[string]*jsonFeature
+}
+
+// newJSONReporter makes the reporter of the json format, which streams when
+// the StreamJSON setting of formatter is set
+func newJSONReporter(formatter *Formatter, writer io.Writer) (TestRunReporter, error) {
+	if formatter.StreamJSON {
+		return makeJSONStreamReporter(formatter, writer)
+	}
+	return makeJSONReporter(formatter, writer), nil
+}
+
+func makeJSONReporter(formatter *Formatter, writer io.Writer) *jsonReporter {
+	return &jsonReporter{
+		formatter:         formatter,
+		writer:            writer,
+		jsonFeatures:      make([]*jsonFeature, 0),
+		jsonFeaturesByURI: make(map[string]*jsonFeature),
+	}
+}
+
+func makeJSONStreamReporter(formatter *Formatter, writer io.Writer) (*jsonReporter, error) {
+	reporter := makeJSONReporter(formatter, writer)
+	reporter.stream = &jsonStreamWriter{writer: writer}
+	return reporter, reporter.stream.start()
+}
+
+func (self *jsonReporter) TestCaseFinished(testCase *TestCase) error {
+	jsonElements, err := self.formatter.makeJsonElements(testCase)
+	if err != nil {
+		return err
+	}
+
+	if self.stream != nil {
+		jsonFeature := self.formatter.makeJsonFeature(testCase.Pickle)
+		for _, jsonElement := range jsonElements {
+			err := self.stream.writeElement(jsonFeature, jsonElement)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	jsonFeature := self.findOrCreateJsonFeature(testCase)
+	jsonFeature.Elements = append(jsonFeature.Elements, jsonElements...)
+	return nil
+}
+
+func (self *jsonReporter) TestRunFinished(testRun *TestRun) error {
+	if self.stream != nil {
+		runFeatures, err := self.runFeatures(testRun)
+		if err != nil {
+			return err
+		}
+		for _, runFeature := range runFeatures {
+			for _, jsonElement := range runFeature.Elements {
+				err = self.stream.writeElement(runFeature, jsonElement)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return self.stream.end()
+	}
+
+	jsonFeatures, err := self.report(testRun)
+	if err != nil {
+		return err
+	}
+	return writeJSON(self.writer, jsonFeatures)
+}
+
+// report returns the features of the test cases, followed by the ones of the
+// test run
+func (self *jsonReporter) report(testRun *TestRun) ([]*jsonFeature, error) {
+	runFeatures, err := self.runFeatures(testRun)
+	if err != nil || len(runFeatures) == 0 {
+		return self.jsonFeatures, err
+	}
+
+	jsonFeatures := make([]*jsonFeature, 0, len(self.jsonFeatures)+len(runFeatures))
+	jsonFeatures = append(jsonFeatures, self.jsonFeatures...)
+	return append(jsonFeatures, runFeatures...), nil
+}
+
+// runFeatures returns the features of the parse errors, then the test run one
+// if there is one
+func (self *jsonReporter) runFeatures(testRun *TestRun) ([]*jsonFeature, error) {
+	runFeatures := makeParseErrorJsonFeatures(testRun.ParseErrors)
+	testRunFeature, err := self.formatter.makeTestRunJsonFeature(testRun.Attachments)
+	if err != nil {
+		return nil, err
+	}
+	if testRunFeature != nil {
+		runFeatures = append(runFeatures, testRunFeature)
+	}
+	return runFeatures, nil
+}
+
+func writeJSON(writer io.Writer, jsonFeatures []*jsonFeature) error {
+	output, err := json.MarshalIndent(jsonFeatures, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(writer, string(output))
+	return err
+}
+
+func (self *jsonReporter) findOrCreateJsonFeature(testCase *TestCase) *jsonFeature {
+	jFeature, ok := self.jsonFeaturesByURI[testCase.Pickle.Uri]
+	if !ok {
+		jFeature = self.formatter.makeJsonFeature(testCase.Pickle)
+		self.jsonFeaturesByURI[testCase.Pickle.Uri] = jFeature
+		self.jsonFeatures = append(self.jsonFeatures, jFeature)
+	}
+	return jFeature
+}
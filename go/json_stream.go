@@ -0,0 +1,112 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// jsonFeatureHeader is a jsonFeature without its elements, which are written
+// one at a time while streaming
+type jsonFeatureHeader struct {
+	Description string     `json:"description"`
+	ID          string     `json:"id"`
+	Keyword     string     `json:"keyword"`
+	Line        uint32     `json:"line"`
+	Name        string     `json:"name"`
+	URI         string     `json:"uri"`
+	Tags        []*jsonTag `json:"tags,omitempty"`
+}
+
+// jsonStreamWriter writes the JSON report one feature element at a time. The
+// consecutive elements of a feature share the same feature object; a feature
+// whose elements are not consecutive is written as several feature objects.
+// The features are told apart by their URI and ID, as the features of the
+// test run and of the parse errors without a source have no URI.
+type jsonStreamWriter struct {
+	writer       io.Writer
+	currentURI   string
+	currentID    string
+	featureCount int
+	elementCount int
+}
+
+func (self *jsonStreamWriter) start() error {
+	_, err := io.WriteString(self.writer, "[")
+	return err
+}
+
+func (self *jsonStreamWriter) writeElement(feature *jsonFeature, element *jsonFeatureElement) error {
+	if self.featureCount == 0 || feature.URI != self.currentURI || feature.ID != self.currentID {
+		err := self.writeFeatureHeader(feature)
+		if err != nil {
+			return err
+		}
+	}
+
+	output, err := json.MarshalIndent(element, "      ", "  ")
+	if err != nil {
+		return err
+	}
+	separator := "\n      "
+	if self.elementCount > 0 {
+		separator = "," + separator
+	}
+	self.elementCount++
+	_, err = io.WriteString(self.writer, separator+string(output))
+	return err
+}
+
+func (self *jsonStreamWriter) writeFeatureHeader(feature *jsonFeature) error {
+	err := self.closeFeature()
+	if err != nil {
+		return err
+	}
+
+	output, err := json.MarshalIndent(&jsonFeatureHeader{
+		Description: feature.Description,
+		ID:          feature.ID,
+		Keyword:     feature.Keyword,
+		Line:        feature.Line,
+		Name:        feature.Name,
+		URI:         feature.URI,
+		Tags:        feature.Tags,
+	}, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	// Reopen the header object to append the elements to it
+	output = bytes.TrimSuffix(output, []byte("\n  }"))
+
+	separator := "\n  "
+	if self.featureCount > 0 {
+		separator = "," + separator
+	}
+	self.featureCount++
+	self.currentURI = feature.URI
+	self.currentID = feature.ID
+	self.elementCount = 0
+	_, err = io.WriteString(self.writer, separator+string(output)+",\n    \"elements\": [")
+	return err
+}
+
+func (self *jsonStreamWriter) closeFeature() error {
+	if self.featureCount == 0 {
+		return nil
+	}
+	_, err := io.WriteString(self.writer, "\n    ]\n  }")
+	return err
+}
+
+func (self *jsonStreamWriter) end() error {
+	err := self.closeFeature()
+	if err != nil {
+		return err
+	}
+	if self.featureCount == 0 {
+		_, err = io.WriteString(self.writer, "]\n")
+		return err
+	}
+	_, err = io.WriteString(self.writer, "\n]\n")
+	return err
+}
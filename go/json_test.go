@@ -0,0 +1,727 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cucumber/common/messages/go/v18"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Formatter", func() {
+	var (
+		formatter *Formatter
+		output    *bytes.Buffer
+		features  []*jsonFeature
+	)
+
+	BeforeEach(func() {
+		formatter = &Formatter{}
+		output = &bytes.Buffer{}
+		features = nil
+	})
+
+	Context("ProcessMessages", func() {
+		It("writes the features of the stream", func() {
+			stream := makeScenarioAStream(messages.TestStepResultStatus_PASSED)
+
+			err := formatter.ProcessMessages(strings.NewReader(stream), output)
+			Expect(err).To(BeNil())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features)).To(Equal(1))
+			Expect(features[0].URI).To(Equal("a.feature"))
+			Expect(len(features[0].Elements)).To(Equal(1))
+		})
+
+		It("logs the processing to the Logger", func() {
+			logs := &bytes.Buffer{}
+			formatter.Logger = log.New(logs, "", 0)
+			stream := makeScenarioAStream(messages.TestStepResultStatus_PASSED)
+
+			Expect(formatter.ProcessMessages(strings.NewReader(stream), output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(logs.String()).To(ContainSubstring("// Formatter: Processed TestCaseStarted (line 5) in "))
+			Expect(logs.String()).To(ContainSubstring("// LookUp: Found item'pickle-id' in pickleByID"))
+		})
+
+		It("does not keep the messages of a previous call", func() {
+			stream := makeScenarioAStream(messages.TestStepResultStatus_PASSED)
+			Expect(formatter.ProcessMessages(strings.NewReader(stream), &bytes.Buffer{})).To(Succeed())
+
+			Expect(formatter.ProcessMessages(strings.NewReader(stream), output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features)).To(Equal(1))
+			Expect(len(features[0].Elements)).To(Equal(1))
+		})
+	})
+
+	Context("ReadMessages", func() {
+		It("merges several streams into a single report", func() {
+			first := makeScenarioAStream(messages.TestStepResultStatus_PASSED)
+			second := makeMessagesStream(makeSingleScenarioRun("b.feature", "Feature B", "Scenario B", messages.TestStepResultStatus_FAILED))
+
+			Expect(formatter.ReadMessages(strings.NewReader(first))).To(Succeed())
+			Expect(formatter.ReadMessages(strings.NewReader(second))).To(Succeed())
+			Expect(formatter.WriteJSON(output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features)).To(Equal(2))
+			Expect(features[0].Name).To(Equal("Feature A"))
+			Expect(features[0].Elements[0].Name).To(Equal("Scenario A"))
+			Expect(features[0].Elements[0].Steps[0].Result.Status).To(Equal("passed"))
+			Expect(features[1].Name).To(Equal("Feature B"))
+			Expect(features[1].Elements[0].Name).To(Equal("Scenario B"))
+			Expect(features[1].Elements[0].Steps[0].Result.Status).To(Equal("failed"))
+		})
+
+		It("merges the features having the same URI", func() {
+			first := makeScenarioAStream(messages.TestStepResultStatus_PASSED)
+			second := makeScenarioAStream(messages.TestStepResultStatus_FAILED)
+
+			Expect(formatter.ReadMessages(strings.NewReader(first))).To(Succeed())
+			Expect(formatter.ReadMessages(strings.NewReader(second))).To(Succeed())
+			Expect(formatter.WriteJSON(output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features)).To(Equal(1))
+			Expect(len(features[0].Elements)).To(Equal(2))
+			Expect(features[0].Elements[0].Steps[0].Result.Status).To(Equal("passed"))
+			Expect(features[0].Elements[1].Steps[0].Result.Status).To(Equal("failed"))
+		})
+
+		It("returns the report before it is serialised", func() {
+			stream := makeScenarioAStream(messages.TestStepResultStatus_FAILED)
+			Expect(formatter.ReadMessages(strings.NewReader(stream))).To(Succeed())
+
+			report, err := formatter.Report()
+
+			Expect(err).To(BeNil())
+			Expect(len(report)).To(Equal(1))
+			Expect(report[0].URI).To(Equal("a.feature"))
+			Expect(report[0].Elements[0].Name).To(Equal("Scenario A"))
+			Expect(report[0].Elements[0].Steps[0].Result.Status).To(Equal("failed"))
+		})
+
+		It("writes an empty report when no messages were read", func() {
+			Expect(formatter.WriteJSON(output)).To(Succeed())
+			Expect(strings.TrimSpace(output.String())).To(Equal("[]"))
+		})
+	})
+
+	Context("Handle", func() {
+		It("reports the envelopes without reading NDJSON", func() {
+			for _, envelope := range makeScenarioARun(messages.TestStepResultStatus_FAILED) {
+				Expect(formatter.Handle(envelope)).To(Succeed())
+			}
+
+			report, err := formatter.Report()
+
+			Expect(err).To(BeNil())
+			Expect(len(report)).To(Equal(1))
+			Expect(report[0].Elements[0].Name).To(Equal("Scenario A"))
+			Expect(report[0].Elements[0].Steps[0].Result.Status).To(Equal("failed"))
+		})
+
+		It("writes the same report as ProcessMessages", func() {
+			envelopes := makeScenarioARun(messages.TestStepResultStatus_PASSED)
+			expected := &bytes.Buffer{}
+			Expect((&Formatter{}).ProcessMessages(strings.NewReader(makeMessagesStream(envelopes)), expected)).To(Succeed())
+
+			for _, envelope := range envelopes {
+				Expect(formatter.Handle(envelope)).To(Succeed())
+			}
+			Expect(formatter.WriteJSON(output)).To(Succeed())
+
+			Expect(output.String()).To(MatchJSON(expected.String()))
+		})
+
+		It("returns the references to unknown messages", func() {
+			err := formatter.Handle(&messages.Envelope{
+				TestCaseFinished: &messages.TestCaseFinished{TestCaseStartedId: "unknown"},
+			})
+
+			var missingReferenceError *MissingReferenceError
+			Expect(errors.As(err, &missingReferenceError)).To(BeTrue())
+		})
+
+		It("can be used by independent Formatters concurrently", func() {
+			statuses := []messages.TestStepResultStatus{
+				messages.TestStepResultStatus_PASSED,
+				messages.TestStepResultStatus_FAILED,
+				messages.TestStepResultStatus_SKIPPED,
+				messages.TestStepResultStatus_PENDING,
+			}
+			reports := make([][]*jsonFeature, len(statuses))
+			errs := make([]error, len(statuses))
+
+			var waitGroup sync.WaitGroup
+			for index, status := range statuses {
+				waitGroup.Add(1)
+				go func(index int, status messages.TestStepResultStatus) {
+					defer waitGroup.Done()
+					formatter := &Formatter{}
+					for _, envelope := range makeScenarioARun(status) {
+						if err := formatter.Handle(envelope); err != nil {
+							errs[index] = err
+							return
+						}
+					}
+					reports[index], errs[index] = formatter.Report()
+				}(index, status)
+			}
+			waitGroup.Wait()
+
+			for index, status := range statuses {
+				Expect(errs[index]).To(BeNil())
+				Expect(len(reports[index])).To(Equal(1))
+				Expect(len(reports[index][0].Elements)).To(Equal(1))
+				Expect(reports[index][0].Elements[0].Steps[0].Result.Status).To(Equal(strings.ToLower(string(status))))
+			}
+		})
+	})
+
+	Context("Success", func() {
+		read := func(envelopes []*messages.Envelope) {
+			Expect(formatter.ReadMessages(strings.NewReader(makeMessagesStream(envelopes)))).To(Succeed())
+		}
+
+		It("follows TestRunFinished when there is one", func() {
+			envelopes := makeScenarioARun(messages.TestStepResultStatus_PASSED)
+			read(append(envelopes, &messages.Envelope{TestRunFinished: &messages.TestRunFinished{Success: false}}))
+
+			Expect(formatter.Success(false)).To(BeFalse())
+		})
+
+		It("fails without TestRunFinished when a test case failed", func() {
+			read(makeScenarioARun(messages.TestStepResultStatus_FAILED))
+
+			Expect(formatter.Success(false)).To(BeFalse())
+		})
+
+		It("succeeds with pending and undefined test cases unless strict", func() {
+			read(makeScenarioARun(messages.TestStepResultStatus_UNDEFINED))
+
+			Expect(formatter.Success(false)).To(BeTrue())
+			Expect(formatter.Success(true)).To(BeFalse())
+		})
+
+		It("fails in strict mode when TestRunFinished succeeded with pending test cases", func() {
+			envelopes := makeScenarioARun(messages.TestStepResultStatus_PENDING)
+			read(append(envelopes, &messages.Envelope{TestRunFinished: &messages.TestRunFinished{Success: true}}))
+
+			Expect(formatter.Success(false)).To(BeTrue())
+			Expect(formatter.Success(true)).To(BeFalse())
+		})
+
+		It("only counts the last attempt of the retried test cases", func() {
+			failed := makeScenarioARun(messages.TestStepResultStatus_FAILED)
+			failed[len(failed)-1].TestCaseFinished.WillBeRetried = true
+			passed := makeScenarioARun(messages.TestStepResultStatus_PASSED)
+			read(append(failed, passed[4:]...))
+
+			Expect(formatter.Success(false)).To(BeTrue())
+		})
+
+		It("succeeds when nothing was read", func() {
+			Expect(formatter.Success(true)).To(BeTrue())
+		})
+	})
+
+	Context("StreamMessages", func() {
+		It("writes the same report as ProcessMessages", func() {
+			stream := makeScenarioAStream(messages.TestStepResultStatus_PASSED)
+			expected := &bytes.Buffer{}
+			Expect((&Formatter{}).ProcessMessages(strings.NewReader(stream), expected)).To(Succeed())
+			var expectedFeatures []*jsonFeature
+			Expect(json.Unmarshal(expected.Bytes(), &expectedFeatures)).To(Succeed())
+
+			Expect(formatter.StreamMessages(strings.NewReader(stream), output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(features).To(Equal(expectedFeatures))
+		})
+
+		It("writes an empty report when no messages were read", func() {
+			Expect(formatter.StreamMessages(strings.NewReader(""), output)).To(Succeed())
+			Expect(strings.TrimSpace(output.String())).To(Equal("[]"))
+		})
+
+		It("writes the features of several streams", func() {
+			first := makeScenarioAStream(messages.TestStepResultStatus_PASSED)
+			second := makeScenarioAStream(messages.TestStepResultStatus_FAILED)
+			third := makeMessagesStream(makeSingleScenarioRun("b.feature", "Feature B", "Scenario B", messages.TestStepResultStatus_PASSED))
+
+			Expect(formatter.StartJSONStream(output)).To(Succeed())
+			Expect(formatter.ReadMessages(strings.NewReader(first))).To(Succeed())
+			Expect(formatter.ReadMessages(strings.NewReader(second))).To(Succeed())
+			Expect(formatter.ReadMessages(strings.NewReader(third))).To(Succeed())
+			Expect(formatter.EndJSONStream()).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features)).To(Equal(2))
+			Expect(features[0].URI).To(Equal("a.feature"))
+			Expect(len(features[0].Elements)).To(Equal(2))
+			Expect(features[1].URI).To(Equal("b.feature"))
+			Expect(len(features[1].Elements)).To(Equal(1))
+		})
+
+		It("writes a feature whose scenarios are interleaved with others as several features", func() {
+			streams := []string{
+				makeScenarioAStream(messages.TestStepResultStatus_PASSED),
+				makeMessagesStream(makeSingleScenarioRun("b.feature", "Feature B", "Scenario B", messages.TestStepResultStatus_PASSED)),
+				makeScenarioAStream(messages.TestStepResultStatus_FAILED),
+			}
+			processed := &Formatter{}
+			for _, stream := range streams {
+				Expect(processed.ReadMessages(strings.NewReader(stream))).To(Succeed())
+			}
+			expected := &bytes.Buffer{}
+			Expect(processed.WriteJSON(expected)).To(Succeed())
+			var expectedFeatures []*jsonFeature
+			Expect(json.Unmarshal(expected.Bytes(), &expectedFeatures)).To(Succeed())
+
+			Expect(formatter.StartJSONStream(output)).To(Succeed())
+			for _, stream := range streams {
+				Expect(formatter.ReadMessages(strings.NewReader(stream))).To(Succeed())
+			}
+			Expect(formatter.EndJSONStream()).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features)).To(Equal(3))
+			Expect(features[0].URI).To(Equal("a.feature"))
+			Expect(features[1].URI).To(Equal("b.feature"))
+			Expect(features[2].URI).To(Equal("a.feature"))
+			Expect(features[2].ID).To(Equal(features[0].ID))
+
+			// Once merged by URI, the features are the ones of the report
+			merged := []*jsonFeature{features[0], features[1]}
+			merged[0].Elements = append(merged[0].Elements, features[2].Elements...)
+			Expect(merged).To(Equal(expectedFeatures))
+		})
+
+		It("forgets the test cases once written", func() {
+			stream := makeScenarioAStream(messages.TestStepResultStatus_PASSED)
+
+			Expect(formatter.StreamMessages(strings.NewReader(stream), output)).To(Succeed())
+
+			Expect(formatter.testCases).To(BeEmpty())
+			Expect(formatter.testCaseByStartedId).To(BeEmpty())
+			Expect(formatter.startedTestCaseIds).To(BeEmpty())
+			Expect(formatter.report.jsonFeatures).To(BeEmpty())
+			Expect(formatter.lookup.LookupPickle("pickle-id")).To(BeNil())
+			Expect(formatter.lookup.LookupTestCase("test-case-id")).To(BeNil())
+		})
+
+		It("returns an error when no stream was started", func() {
+			Expect(formatter.EndJSONStream()).NotTo(Succeed())
+		})
+	})
+
+	Context("when attachments are not attached to a step", func() {
+		var stream string
+
+		BeforeEach(func() {
+			envelopes := makeScenarioARun(messages.TestStepResultStatus_PASSED)
+			testCaseFinished := envelopes[len(envelopes)-1]
+			envelopes = append(envelopes[:len(envelopes)-1],
+				&messages.Envelope{
+					Attachment: &messages.Attachment{
+						TestCaseStartedId: "test-case-started-id",
+						Body:              "test case log",
+						MediaType:         "text/x.cucumber.log+plain",
+					},
+				},
+				testCaseFinished,
+				&messages.Envelope{
+					Attachment: &messages.Attachment{
+						Body:      "test run log",
+						MediaType: "text/x.cucumber.log+plain",
+					},
+				},
+			)
+			stream = makeMessagesStream(envelopes)
+		})
+
+		It("reports the attachments of a test case on its scenario", func() {
+			Expect(formatter.ProcessMessages(strings.NewReader(stream), output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(features[0].Elements[0].Output).To(Equal([]string{"test case log"}))
+		})
+
+		It("reports the attachments of the run in a feature of their own", func() {
+			Expect(formatter.ProcessMessages(strings.NewReader(stream), output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features)).To(Equal(2))
+			Expect(features[1].ID).To(Equal(TestRunFeatureID))
+			Expect(features[1].URI).To(BeEmpty())
+			Expect(features[1].Elements[0].Type).To(Equal("test_run"))
+			Expect(features[1].Elements[0].Output).To(Equal([]string{"test run log"}))
+		})
+
+		It("reports the attachments of the run at the end of a JSON stream", func() {
+			Expect(formatter.StreamMessages(strings.NewReader(stream), output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features)).To(Equal(2))
+			Expect(features[0].Elements[0].Output).To(Equal([]string{"test case log"}))
+			Expect(features[1].ID).To(Equal(TestRunFeatureID))
+			Expect(features[1].Elements[0].Output).To(Equal([]string{"test run log"}))
+		})
+
+		It("reports the attachments of a finished test case with the attachments of the run", func() {
+			envelopes := makeScenarioARun(messages.TestStepResultStatus_PASSED)
+			envelopes = append(envelopes, &messages.Envelope{
+				Attachment: &messages.Attachment{
+					TestCaseStartedId: "test-case-started-id",
+					TestStepId:        "test-step-id",
+					Body:              "late log",
+					MediaType:         "text/x.cucumber.log+plain",
+				},
+			})
+
+			Expect(formatter.ProcessMessages(strings.NewReader(makeMessagesStream(envelopes)), output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features)).To(Equal(2))
+			Expect(features[1].ID).To(Equal(TestRunFeatureID))
+			Expect(features[1].Elements[0].Output).To(Equal([]string{"late log"}))
+		})
+	})
+
+	Context("when a feature file can not be parsed", func() {
+		var stream string
+
+		BeforeEach(func() {
+			envelopes := []*messages.Envelope{
+				{GherkinDocument: &messages.GherkinDocument{Uri: "broken.feature"}},
+				{
+					ParseError: &messages.ParseError{
+						Source: &messages.SourceReference{
+							Uri:      "broken.feature",
+							Location: &messages.Location{Line: 3, Column: 1},
+						},
+						Message: "(3:1): expected: #TagLine, #RuleLine, #Comment, #Empty, got 'Oops'",
+					},
+				},
+			}
+			envelopes = append(envelopes, makeScenarioARun(messages.TestStepResultStatus_PASSED)...)
+			stream = makeMessagesStream(envelopes)
+		})
+
+		It("reports the parse errors as a failed feature", func() {
+			Expect(formatter.ProcessMessages(strings.NewReader(stream), output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features)).To(Equal(2))
+			Expect(features[0].URI).To(Equal("a.feature"))
+			Expect(features[1].URI).To(Equal("broken.feature"))
+			element := features[1].Elements[0]
+			Expect(element.Name).To(Equal(ParseErrorName))
+			Expect(element.Line).To(Equal(uint32(3)))
+			Expect(element.Before[0].Result.Status).To(Equal("failed"))
+			Expect(element.Before[0].Result.ErrorMessage).To(ContainSubstring("got 'Oops'"))
+			Expect(element.Before[0].Match.Location).To(Equal("broken.feature:3"))
+		})
+
+		It("reports the parse errors at the end of a JSON stream", func() {
+			Expect(formatter.StreamMessages(strings.NewReader(stream), output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features)).To(Equal(2))
+			Expect(features[1].Elements[0].Name).To(Equal(ParseErrorName))
+		})
+
+		It("keeps the parse errors without a source apart from the test run in a JSON stream", func() {
+			envelopes := []*messages.Envelope{
+				{ParseError: &messages.ParseError{Message: "no source"}},
+				{Attachment: &messages.Attachment{Body: "run", MediaType: "text/plain"}},
+			}
+
+			Expect(formatter.StreamMessages(strings.NewReader(makeMessagesStream(envelopes)), output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features)).To(Equal(2))
+			Expect(features[0].ID).To(Equal("parse-error"))
+			Expect(features[0].Name).To(Equal(ParseErrorName))
+			Expect(len(features[0].Elements)).To(Equal(1))
+			Expect(features[1].ID).To(Equal(TestRunFeatureID))
+			Expect(len(features[1].Elements)).To(Equal(1))
+		})
+
+		It("reports the parse errors as failed JUnit testcases", func() {
+			Expect(formatter.ReadMessages(strings.NewReader(stream))).To(Succeed())
+			Expect(formatter.WriteJUnit(output)).To(Succeed())
+
+			Expect(output.String()).To(ContainSubstring(`<testsuite name="broken.feature" tests="1" failures="1"`))
+			Expect(output.String()).To(ContainSubstring(`<testcase classname="broken.feature" name="Parse error" time="0.000">`))
+		})
+
+		It("makes the test run fail", func() {
+			Expect(formatter.ReadMessages(strings.NewReader(stream))).To(Succeed())
+
+			Expect(formatter.Success(false)).To(BeFalse())
+		})
+	})
+
+	Context("when a test case is retried", func() {
+		var stream string
+
+		BeforeEach(func() {
+			envelopes := makeScenarioARun(messages.TestStepResultStatus_FAILED)
+			envelopes[len(envelopes)-1].TestCaseFinished.WillBeRetried = true
+			envelopes = append(envelopes,
+				makeTestCaseStartedEnvelope(&messages.TestCaseStarted{
+					Id:         "second-test-case-started-id",
+					TestCaseId: "test-case-id",
+					Attempt:    1,
+				}),
+				&messages.Envelope{
+					TestStepFinished: &messages.TestStepFinished{
+						TestCaseStartedId: "second-test-case-started-id",
+						TestStepId:        "test-step-id",
+						TestStepResult: &messages.TestStepResult{
+							Status:   messages.TestStepResultStatus_PASSED,
+							Duration: &messages.Duration{},
+						},
+					},
+				},
+				&messages.Envelope{
+					TestCaseFinished: &messages.TestCaseFinished{
+						TestCaseStartedId: "second-test-case-started-id",
+					},
+				},
+			)
+			stream = makeMessagesStream(envelopes)
+		})
+
+		It("reports every attempt by default", func() {
+			Expect(formatter.ProcessMessages(strings.NewReader(stream), output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features[0].Elements)).To(Equal(2))
+			Expect(features[0].Elements[0].Steps[0].Result.Status).To(Equal("failed"))
+			Expect(features[0].Elements[0].Attempt).To(Equal(int64(0)))
+			Expect(features[0].Elements[1].Steps[0].Result.Status).To(Equal("passed"))
+			Expect(features[0].Elements[1].Attempt).To(Equal(int64(0)))
+		})
+
+		It("only reports the final attempt with RetryModeLast", func() {
+			formatter.RetryMode = RetryModeLast
+			Expect(formatter.ProcessMessages(strings.NewReader(stream), output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features[0].Elements)).To(Equal(1))
+			Expect(features[0].Elements[0].Steps[0].Result.Status).To(Equal("passed"))
+		})
+
+		It("annotates every attempt with RetryModeAnnotated", func() {
+			formatter.RetryMode = RetryModeAnnotated
+			Expect(formatter.ProcessMessages(strings.NewReader(stream), output)).To(Succeed())
+
+			Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+			Expect(len(features[0].Elements)).To(Equal(2))
+			Expect(features[0].Elements[0].Attempt).To(Equal(int64(1)))
+			Expect(features[0].Elements[1].Attempt).To(Equal(int64(2)))
+		})
+
+		It("tells the reporters which attempts will be retried", func() {
+			reporter := &recordingReporter{}
+			formatter.AddReporter(reporter)
+			Expect(formatter.ReadMessages(strings.NewReader(stream))).To(Succeed())
+
+			Expect(len(reporter.testCases)).To(Equal(2))
+			Expect(reporter.testCases[0].WillBeRetried).To(BeTrue())
+			Expect(reporter.testCases[1].WillBeRetried).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Formatter with an inconsistent stream", func() {
+	var (
+		formatter *Formatter
+		envelopes []*messages.Envelope
+	)
+
+	BeforeEach(func() {
+		formatter = &Formatter{}
+		envelopes = makeScenarioARun(messages.TestStepResultStatus_PASSED)
+	})
+
+	It("returns a MissingReferenceError when a message references an unknown message", func() {
+		// Remove the TestCaseStarted envelope
+		envelopes = append(envelopes[:4], envelopes[5:]...)
+		stream := makeMessagesStream(envelopes)
+
+		err := formatter.ProcessMessages(strings.NewReader(stream), &bytes.Buffer{})
+
+		var missingReferenceError *MissingReferenceError
+		Expect(errors.As(err, &missingReferenceError)).To(BeTrue())
+		Expect(missingReferenceError.Kind).To(Equal("TestCaseStarted"))
+		Expect(missingReferenceError.ID).To(Equal("test-case-started-id"))
+		Expect(missingReferenceError.Envelope).To(Equal("TestStepFinished"))
+		Expect(missingReferenceError.Line).To(Equal(5))
+		Expect(err.Error()).To(Equal(`line 5: no TestCaseStarted for "test-case-started-id" referenced by TestStepFinished`))
+	})
+
+	It("returns a MissingReferenceError when the messages are reordered", func() {
+		envelopes[4], envelopes[5] = envelopes[5], envelopes[4]
+		stream := makeMessagesStream(envelopes)
+
+		err := formatter.ProcessMessages(strings.NewReader(stream), &bytes.Buffer{})
+
+		var missingReferenceError *MissingReferenceError
+		Expect(errors.As(err, &missingReferenceError)).To(BeTrue())
+		Expect(missingReferenceError.Envelope).To(Equal("TestStepFinished"))
+		Expect(missingReferenceError.Line).To(Equal(5))
+	})
+
+	It("returns a DecodeError when the stream is truncated", func() {
+		stream := makeMessagesStream(envelopes)
+		stream = stream[:len(stream)-10]
+
+		err := formatter.ProcessMessages(strings.NewReader(stream), &bytes.Buffer{})
+
+		var decodeError *DecodeError
+		Expect(errors.As(err, &decodeError)).To(BeTrue())
+		Expect(decodeError.Line).To(Equal(7))
+	})
+
+	It("ignores blank lines", func() {
+		stream := "\n" + makeMessagesStream(envelopes) + "\n"
+
+		Expect(formatter.ProcessMessages(strings.NewReader(stream), &bytes.Buffer{})).To(Succeed())
+	})
+})
+
+var _ = Describe("Formatter in lenient mode", func() {
+	var (
+		formatter *Formatter
+		output    *bytes.Buffer
+		features  []*jsonFeature
+		envelopes []*messages.Envelope
+	)
+
+	BeforeEach(func() {
+		formatter = &Formatter{Lenient: true}
+		output = &bytes.Buffer{}
+		features = nil
+		envelopes = makeScenarioARun(messages.TestStepResultStatus_PASSED)
+	})
+
+	It("skips the envelopes which can not be decoded", func() {
+		stream := makeMessagesStream(envelopes[:3]) + "{\"pickle\": \n" + makeMessagesStream(envelopes[3:])
+
+		Expect(formatter.ProcessMessages(strings.NewReader(stream), output)).To(Succeed())
+
+		Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+		Expect(len(features[0].Elements)).To(Equal(1))
+		Expect(len(formatter.Diagnostics())).To(Equal(1))
+		var decodeError *DecodeError
+		Expect(errors.As(formatter.Diagnostics()[0], &decodeError)).To(BeTrue())
+		Expect(decodeError.Line).To(Equal(4))
+	})
+
+	It("skips the envelopes which can not be resolved", func() {
+		envelopes = append(envelopes, &messages.Envelope{
+			TestCaseFinished: &messages.TestCaseFinished{
+				TestCaseStartedId: "unknown-test-case-started-id",
+			},
+		})
+		stream := makeMessagesStream(envelopes)
+
+		Expect(formatter.ProcessMessages(strings.NewReader(stream), output)).To(Succeed())
+
+		Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+		Expect(len(features[0].Elements)).To(Equal(1))
+		Expect(len(formatter.Diagnostics())).To(Equal(1))
+		var missingReferenceError *MissingReferenceError
+		Expect(errors.As(formatter.Diagnostics()[0], &missingReferenceError)).To(BeTrue())
+		Expect(missingReferenceError.Line).To(Equal(8))
+	})
+
+	It("fails the step which did not finish", func() {
+		stream := makeMessagesStream(envelopes[:5])
+
+		Expect(formatter.ProcessMessages(strings.NewReader(stream), output)).To(Succeed())
+
+		Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+		Expect(len(features[0].Elements)).To(Equal(1))
+		result := features[0].Elements[0].Steps[0].Result
+		Expect(result.Status).To(Equal("failed"))
+		Expect(result.ErrorMessage).To(Equal(InterruptedMessage))
+		Expect(len(formatter.Diagnostics())).To(Equal(1))
+	})
+
+	It("fails the test case which did not finish after its last step", func() {
+		stream := makeMessagesStream(envelopes[:6])
+
+		Expect(formatter.ProcessMessages(strings.NewReader(stream), output)).To(Succeed())
+
+		Expect(json.Unmarshal(output.Bytes(), &features)).To(Succeed())
+		result := features[0].Elements[0].Steps[0].Result
+		Expect(result.Status).To(Equal("failed"))
+		Expect(result.ErrorMessage).To(Equal(InterruptedMessage))
+	})
+
+	It("has no diagnostics for a consistent stream", func() {
+		stream := makeMessagesStream(envelopes)
+
+		Expect(formatter.ProcessMessages(strings.NewReader(stream), output)).To(Succeed())
+		Expect(formatter.Diagnostics()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("ParseRetryMode", func() {
+	It("returns the RetryMode", func() {
+		mode, err := ParseRetryMode("last")
+		Expect(err).To(BeNil())
+		Expect(mode).To(Equal(RetryModeLast))
+	})
+
+	It("returns an error for unknown modes", func() {
+		_, err := ParseRetryMode("first")
+		Expect(err).NotTo(BeNil())
+	})
+})
+
+// The golden files of testdata/retry, named <messages>.<retry mode>.json, are
+// written by hand from the reports of cucumber-ruby, which only knows of
+// RetryModeAll, so that each RetryMode is checked against its own reference
+var _ = Describe("Formatter with a RetryMode", func() {
+	goldenFiles, _ := filepath.Glob("testdata/retry/*.json")
+
+	for _, goldenFile := range goldenFiles {
+		goldenFile := goldenFile
+		extension := filepath.Ext(strings.TrimSuffix(goldenFile, ".json"))
+		retryMode := RetryMode(strings.TrimPrefix(extension, "."))
+		messagesFile := strings.TrimSuffix(goldenFile, extension+".json") + ".ndjson"
+
+		It("renders "+filepath.Base(messagesFile)+" with the "+string(retryMode)+" retry mode", func() {
+			expected, err := ioutil.ReadFile(goldenFile)
+			Expect(err).To(BeNil())
+			reader, err := os.Open(messagesFile)
+			Expect(err).To(BeNil())
+			defer reader.Close()
+
+			output := &bytes.Buffer{}
+			formatter := &Formatter{RetryMode: retryMode}
+			formatter.Dialect = DialectRuby
+			Expect(formatter.ProcessMessages(reader, output)).To(Succeed())
+
+			Expect(output.String()).To(MatchJSON(expected))
+		})
+	}
+})
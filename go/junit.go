@@ -0,0 +1,271 @@
+package json
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cucumber/common/messages/go/v18"
+)
+
+type junitTestSuites struct {
+	XMLName    xml.Name          `xml:"testsuites"`
+	Name       string            `xml:"name,attr"`
+	Tests      int               `xml:"tests,attr"`
+	Failures   int               `xml:"failures,attr"`
+	Skipped    int               `xml:"skipped,attr"`
+	Errors     int               `xml:"errors,attr"`
+	Time       string            `xml:"time,attr"`
+	TestSuites []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Skipped   int              `xml:"skipped,attr"`
+	Errors    int              `xml:"errors,attr"`
+	Time      string           `xml:"time,attr"`
+	TestCases []*junitTestCase `xml:"testcase"`
+
+	duration time.Duration
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr"`
+	Content string `xml:",cdata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+// WriteJUnit writes a JUnit XML report of all the messages read so far: each
+// feature is a testsuite and each pickle a testcase.
+func (self *Formatter) WriteJUnit(writer io.Writer) error {
+	if self.lookup == nil {
+		self.initialize()
+	}
+
+	return writeJUnit(writer, self.testCases, self.parseErrors)
+}
+
+// junitReporter renders the test cases as a JUnit XML report once the run finished
+type junitReporter struct {
+	writer    io.Writer
+	testCases []*TestCase
+}
+
+func newJUnitReporter(formatter *Formatter, writer io.Writer) (TestRunReporter, error) {
+	return &junitReporter{
+		writer:    writer,
+		testCases: make([]*TestCase, 0),
+	}, nil
+}
+
+func (self *junitReporter) TestCaseFinished(testCase *TestCase) error {
+	self.testCases = append(self.testCases, testCase)
+	return nil
+}
+
+func (self *junitReporter) TestRunFinished(testRun *TestRun) error {
+	return writeJUnit(self.writer, self.testCases, testRun.ParseErrors)
+}
+
+func writeJUnit(writer io.Writer, testCases []*TestCase, parseErrors []*messages.ParseError) error {
+	report := makeJUnitReport(testCases, parseErrors)
+	output, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "%s%s\n", xml.Header, output)
+	return err
+}
+
+// makeJUnitReport groups the test cases by feature, in the order they were run.
+// Only the final attempt of a retried test case is a testcase. The feature
+// files which could not be parsed have a failed testcase for each parse error.
+func makeJUnitReport(testCases []*TestCase, parseErrors []*messages.ParseError) *junitTestSuites {
+	report := &junitTestSuites{
+		Name:       "Cucumber",
+		TestSuites: make([]*junitTestSuite, 0),
+	}
+	testSuiteByURI := make(map[string]*junitTestSuite)
+	duration := time.Duration(0)
+
+	for _, testCase := range testCases {
+		if testCase.WillBeRetried {
+			continue
+		}
+		testSuite, ok := testSuiteByURI[testCase.Pickle.Uri]
+		if !ok {
+			testSuite = &junitTestSuite{
+				Name:      testCase.FeatureName,
+				TestCases: make([]*junitTestCase, 0),
+			}
+			testSuiteByURI[testCase.Pickle.Uri] = testSuite
+			report.TestSuites = append(report.TestSuites, testSuite)
+		}
+
+		junitTestCase := testCaseToJUnit(testCase)
+		testSuite.TestCases = append(testSuite.TestCases, junitTestCase)
+		testSuite.Tests++
+		if junitTestCase.Failure != nil {
+			testSuite.Failures++
+		}
+		if junitTestCase.Skipped != nil {
+			testSuite.Skipped++
+		}
+		testCaseDuration := testCaseDuration(testCase)
+		testSuite.duration += testCaseDuration
+		testSuite.Time = formatJUnitDuration(testSuite.duration)
+		duration += testCaseDuration
+	}
+
+	for _, parseError := range parseErrors {
+		uri, _ := parseErrorLocation(parseError)
+		// The parse errors without a source are named after what they are
+		name := uri
+		if name == "" {
+			name = ParseErrorName
+		}
+		testSuite, ok := testSuiteByURI[uri]
+		if !ok {
+			testSuite = &junitTestSuite{
+				Name:      name,
+				Time:      formatJUnitDuration(0),
+				TestCases: make([]*junitTestCase, 0),
+			}
+			testSuiteByURI[uri] = testSuite
+			report.TestSuites = append(report.TestSuites, testSuite)
+		}
+
+		testSuite.TestCases = append(testSuite.TestCases, &junitTestCase{
+			ClassName: name,
+			Name:      ParseErrorName,
+			Time:      formatJUnitDuration(0),
+			Failure: &junitFailure{
+				Message: firstLine(parseError.Message),
+				Type:    makeStatus(messages.TestStepResultStatus_FAILED),
+				Content: parseError.Message,
+			},
+		})
+		testSuite.Tests++
+		testSuite.Failures++
+	}
+
+	for _, testSuite := range report.TestSuites {
+		report.Tests += testSuite.Tests
+		report.Failures += testSuite.Failures
+		report.Skipped += testSuite.Skipped
+	}
+	report.Time = formatJUnitDuration(duration)
+
+	return report
+}
+
+// testCaseToJUnit renders a test case: failed and ambiguous steps make it fail,
+// pending, undefined and skipped steps make it skipped.
+func testCaseToJUnit(testCase *TestCase) *junitTestCase {
+	junitTestCase := &junitTestCase{
+		ClassName: testCase.FeatureName,
+		Name:      testCase.Pickle.Name,
+		Time:      formatJUnitDuration(testCaseDuration(testCase)),
+		SystemOut: makeJUnitSystemOut(testCase),
+	}
+
+	step := firstStepWithStatus(testCase, messages.TestStepResultStatus_FAILED, messages.TestStepResultStatus_AMBIGUOUS)
+	if step != nil {
+		junitTestCase.Failure = &junitFailure{
+			Message: firstLine(step.Result.Message),
+			Type:    makeStatus(step.Result.Status),
+			Content: step.Result.Message,
+		}
+		return junitTestCase
+	}
+
+	step = firstStepWithStatus(
+		testCase,
+		messages.TestStepResultStatus_PENDING,
+		messages.TestStepResultStatus_UNDEFINED,
+		messages.TestStepResultStatus_UNKNOWN,
+		messages.TestStepResultStatus_SKIPPED,
+	)
+	if step != nil {
+		junitTestCase.Skipped = &junitSkipped{
+			Message: makeJUnitSkippedMessage(step),
+		}
+	}
+
+	return junitTestCase
+}
+
+func firstStepWithStatus(testCase *TestCase, statuses ...messages.TestStepResultStatus) *TestStep {
+	for _, status := range statuses {
+		for _, step := range testCase.Steps {
+			if step.Result.Status == status {
+				return step
+			}
+		}
+	}
+	return nil
+}
+
+func makeJUnitSkippedMessage(step *TestStep) string {
+	status := makeStatus(step.Result.Status)
+	if step.PickleStep == nil {
+		return fmt.Sprintf("%s hook", status)
+	}
+	if step.Result.Message != "" {
+		return fmt.Sprintf("%s step: %s (%s)", status, step.PickleStep.Text, firstLine(step.Result.Message))
+	}
+	return fmt.Sprintf("%s step: %s", status, step.PickleStep.Text)
+}
+
+// makeJUnitSystemOut lists the steps with their status, as cucumber-jvm does
+func makeJUnitSystemOut(testCase *TestCase) string {
+	var builder strings.Builder
+	for _, step := range testCase.Steps {
+		if step.PickleStep == nil {
+			continue
+		}
+		text := strings.TrimSpace(step.Step.Keyword) + " " + step.PickleStep.Text
+		dots := 1
+		if len(text) < 79 {
+			dots = 80 - len(text)
+		}
+		builder.WriteString(text + strings.Repeat(".", dots) + makeStatus(step.Result.Status) + "\n")
+	}
+	return builder.String()
+}
+
+func testCaseDuration(testCase *TestCase) time.Duration {
+	duration := time.Duration(0)
+	for _, step := range testCase.Steps {
+		if step.Result.Duration != nil {
+			duration += messages.DurationToGoDuration(*step.Result.Duration)
+		}
+	}
+	return duration
+}
+
+func formatJUnitDuration(duration time.Duration) string {
+	return strconv.FormatFloat(duration.Seconds(), 'f', 3, 64)
+}
+
+func firstLine(s string) string {
+	return strings.SplitN(s, "\n", 2)[0]
+}
@@ -0,0 +1,177 @@
+package json
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+
+	"github.com/cucumber/common/messages/go/v18"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("testCaseToJUnit", func() {
+	var (
+		testCase *TestCase
+		makeStep func(status messages.TestStepResultStatus, message string) *TestStep
+	)
+
+	BeforeEach(func() {
+		testCase = &TestCase{
+			FeatureName: "My feature",
+			Pickle: &messages.Pickle{
+				Uri:  "my_feature.feature",
+				Name: "A scenario (2)",
+			},
+			Steps: make([]*TestStep, 0),
+		}
+
+		makeStep = func(status messages.TestStepResultStatus, message string) *TestStep {
+			return &TestStep{
+				Step: &messages.Step{
+					Keyword: "Given ",
+				},
+				PickleStep: &messages.PickleStep{
+					Text: "a " + strings.ToLower(string(status)) + " step",
+				},
+				Result: &messages.TestStepResult{
+					Status:  status,
+					Message: message,
+					Duration: &messages.Duration{
+						Seconds: 1,
+						Nanos:   500000000,
+					},
+				},
+			}
+		}
+	})
+
+	It("has the feature name as classname and the pickle name as name", func() {
+		junitTestCase := testCaseToJUnit(testCase)
+		Expect(junitTestCase.ClassName).To(Equal("My feature"))
+		Expect(junitTestCase.Name).To(Equal("A scenario (2)"))
+	})
+
+	It("sums the durations of the steps", func() {
+		testCase.appendStep(makeStep(messages.TestStepResultStatus_PASSED, ""))
+		testCase.appendStep(&TestStep{
+			Hook: &messages.Hook{},
+			Result: &messages.TestStepResult{
+				Status:   messages.TestStepResultStatus_PASSED,
+				Duration: &messages.Duration{Seconds: 2},
+			},
+		})
+
+		Expect(testCaseToJUnit(testCase).Time).To(Equal("3.500"))
+	})
+
+	It("is neither failed nor skipped when the steps passed", func() {
+		testCase.appendStep(makeStep(messages.TestStepResultStatus_PASSED, ""))
+
+		junitTestCase := testCaseToJUnit(testCase)
+		Expect(junitTestCase.Failure).To(BeNil())
+		Expect(junitTestCase.Skipped).To(BeNil())
+	})
+
+	It("has a failure with the message of the failed step", func() {
+		testCase.appendStep(makeStep(messages.TestStepResultStatus_PASSED, ""))
+		testCase.appendStep(makeStep(messages.TestStepResultStatus_FAILED, "Boom\nstack trace"))
+		testCase.appendStep(makeStep(messages.TestStepResultStatus_SKIPPED, ""))
+
+		junitTestCase := testCaseToJUnit(testCase)
+		Expect(junitTestCase.Skipped).To(BeNil())
+		Expect(junitTestCase.Failure.Message).To(Equal("Boom"))
+		Expect(junitTestCase.Failure.Type).To(Equal("failed"))
+		Expect(junitTestCase.Failure.Content).To(Equal("Boom\nstack trace"))
+	})
+
+	It("is skipped when a step is pending", func() {
+		testCase.appendStep(makeStep(messages.TestStepResultStatus_PENDING, "TODO"))
+		testCase.appendStep(makeStep(messages.TestStepResultStatus_SKIPPED, ""))
+
+		junitTestCase := testCaseToJUnit(testCase)
+		Expect(junitTestCase.Failure).To(BeNil())
+		Expect(junitTestCase.Skipped.Message).To(Equal("pending step: a pending step (TODO)"))
+	})
+
+	It("is skipped when a step is undefined", func() {
+		testCase.appendStep(makeStep(messages.TestStepResultStatus_UNDEFINED, ""))
+
+		junitTestCase := testCaseToJUnit(testCase)
+		Expect(junitTestCase.Skipped.Message).To(Equal("undefined step: a undefined step"))
+	})
+
+	It("lists the steps in system-out", func() {
+		testCase.appendStep(makeStep(messages.TestStepResultStatus_PASSED, ""))
+
+		junitTestCase := testCaseToJUnit(testCase)
+		Expect(junitTestCase.SystemOut).To(Equal("Given a passed step" + strings.Repeat(".", 61) + "passed\n"))
+	})
+})
+
+var _ = Describe("Formatter.WriteJUnit", func() {
+	var (
+		formatter *Formatter
+		output    *bytes.Buffer
+		report    junitTestSuites
+	)
+
+	BeforeEach(func() {
+		formatter = &Formatter{}
+		output = &bytes.Buffer{}
+		report = junitTestSuites{}
+	})
+
+	It("has a testsuite per feature", func() {
+		first := makeScenarioAStream(messages.TestStepResultStatus_PASSED)
+		second := makeMessagesStream(makeSingleScenarioRun("b.feature", "Feature B", "Scenario B", messages.TestStepResultStatus_FAILED))
+		third := makeScenarioAStream(messages.TestStepResultStatus_UNDEFINED)
+
+		Expect(formatter.ReadMessages(strings.NewReader(first))).To(Succeed())
+		Expect(formatter.ReadMessages(strings.NewReader(second))).To(Succeed())
+		Expect(formatter.ReadMessages(strings.NewReader(third))).To(Succeed())
+		Expect(formatter.WriteJUnit(output)).To(Succeed())
+
+		Expect(output.String()).To(HavePrefix(xml.Header))
+		Expect(xml.Unmarshal(output.Bytes(), &report)).To(Succeed())
+		Expect(report.Tests).To(Equal(3))
+		Expect(report.Failures).To(Equal(1))
+		Expect(report.Skipped).To(Equal(1))
+		Expect(len(report.TestSuites)).To(Equal(2))
+		Expect(report.TestSuites[0].Name).To(Equal("Feature A"))
+		Expect(report.TestSuites[0].Tests).To(Equal(2))
+		Expect(report.TestSuites[0].Skipped).To(Equal(1))
+		Expect(report.TestSuites[0].TestCases[0].Name).To(Equal("Scenario A"))
+		Expect(report.TestSuites[1].Name).To(Equal("Feature B"))
+		Expect(report.TestSuites[1].Failures).To(Equal(1))
+	})
+
+	It("only has the final attempt of the retried scenarios", func() {
+		failed := makeScenarioARun(messages.TestStepResultStatus_FAILED)
+		failed[len(failed)-1].TestCaseFinished.WillBeRetried = true
+		passed := makeScenarioARun(messages.TestStepResultStatus_PASSED)
+
+		Expect(formatter.ReadMessages(strings.NewReader(makeMessagesStream(append(failed, passed[4:]...))))).To(Succeed())
+		Expect(formatter.WriteJUnit(output)).To(Succeed())
+
+		Expect(xml.Unmarshal(output.Bytes(), &report)).To(Succeed())
+		Expect(report.Tests).To(Equal(1))
+		Expect(report.Failures).To(Equal(0))
+		Expect(len(report.TestSuites[0].TestCases)).To(Equal(1))
+	})
+
+	It("names the testsuite of the parse errors without a source", func() {
+		stream := makeMessagesStream([]*messages.Envelope{
+			{ParseError: &messages.ParseError{Message: "no source"}},
+		})
+
+		Expect(formatter.ReadMessages(strings.NewReader(stream))).To(Succeed())
+		Expect(formatter.WriteJUnit(output)).To(Succeed())
+
+		Expect(xml.Unmarshal(output.Bytes(), &report)).To(Succeed())
+		Expect(report.Failures).To(Equal(1))
+		Expect(report.TestSuites[0].Name).To(Equal(ParseErrorName))
+		Expect(report.TestSuites[0].TestCases[0].ClassName).To(Equal(ParseErrorName))
+		Expect(report.TestSuites[0].TestCases[0].Failure.Message).To(Equal("no source"))
+	})
+})
@@ -0,0 +1,242 @@
+package json
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cucumber/common/messages/go/v18"
+)
+
+// markdownStatuses are the columns of the tables of the markdown report
+var markdownStatuses = []messages.TestStepResultStatus{
+	messages.TestStepResultStatus_PASSED,
+	messages.TestStepResultStatus_FAILED,
+	messages.TestStepResultStatus_AMBIGUOUS,
+	messages.TestStepResultStatus_UNDEFINED,
+	messages.TestStepResultStatus_PENDING,
+	messages.TestStepResultStatus_SKIPPED,
+}
+
+const (
+	// markdownSlowestCount is the number of scenarios listed as the slowest ones
+	markdownSlowestCount = 10
+	// markdownErrorMaxLength is the number of characters of the error messages
+	// of the failed scenarios
+	markdownErrorMaxLength = 200
+)
+
+// markdownReporter writes a summary of the run in GitHub Flavored Markdown,
+// for the CI step summaries and the merge request comments
+type markdownReporter struct {
+	writer    io.Writer
+	testCases []*TestCase
+}
+
+func newMarkdownReporter(formatter *Formatter, writer io.Writer) (TestRunReporter, error) {
+	return &markdownReporter{
+		writer:    writer,
+		testCases: make([]*TestCase, 0),
+	}, nil
+}
+
+func (self *markdownReporter) TestCaseFinished(testCase *TestCase) error {
+	// Only the final attempt of a retried test case is summarised
+	if !testCase.WillBeRetried {
+		self.testCases = append(self.testCases, testCase)
+	}
+	return nil
+}
+
+func (self *markdownReporter) TestRunFinished(testRun *TestRun) error {
+	_, err := io.WriteString(self.writer, makeMarkdownReport(self.testCases, testRun.ParseErrors))
+	return err
+}
+
+// markdownCounts counts the scenarios, or the steps, by status
+type markdownCounts struct {
+	total    int
+	byStatus map[string]int
+	duration time.Duration
+}
+
+func (self *markdownCounts) add(status messages.TestStepResultStatus) {
+	if self.byStatus == nil {
+		self.byStatus = make(map[string]int)
+	}
+	self.total++
+	self.byStatus[makeStatus(status)]++
+}
+
+func (self *markdownCounts) cells() string {
+	cells := make([]string, len(markdownStatuses))
+	for index, status := range markdownStatuses {
+		cells[index] = fmt.Sprint(self.byStatus[makeStatus(status)])
+	}
+	return fmt.Sprintf("%d | %s", self.total, strings.Join(cells, " | "))
+}
+
+// makeMarkdownReport summarises the test cases, and the feature files which
+// could not be parsed as failed scenarios
+func makeMarkdownReport(testCases []*TestCase, parseErrors []*messages.ParseError) string {
+	var builder strings.Builder
+	scenarios := &markdownCounts{}
+	steps := &markdownCounts{}
+	features := make([]string, 0)
+	featureNames := make(map[string]string)
+	scenariosByFeature := make(map[string]*markdownCounts)
+	failedTestCases := make([]*TestCase, 0)
+
+	for _, testCase := range testCases {
+		status := testCase.Status()
+		duration := testCaseDuration(testCase)
+		scenarios.add(status)
+		scenarios.duration += duration
+		for _, step := range testCase.Steps {
+			if step.PickleStep != nil {
+				steps.add(step.Result.Status)
+			}
+		}
+
+		uri := testCase.Pickle.Uri
+		featureScenarios, ok := scenariosByFeature[uri]
+		if !ok {
+			featureScenarios = &markdownCounts{}
+			scenariosByFeature[uri] = featureScenarios
+			featureNames[uri] = testCase.FeatureName
+			features = append(features, uri)
+		}
+		featureScenarios.add(status)
+		featureScenarios.duration += duration
+
+		if status == messages.TestStepResultStatus_FAILED || status == messages.TestStepResultStatus_AMBIGUOUS {
+			failedTestCases = append(failedTestCases, testCase)
+		}
+	}
+
+	for _, parseError := range parseErrors {
+		uri, _ := parseErrorLocation(parseError)
+		scenarios.add(messages.TestStepResultStatus_FAILED)
+		featureScenarios, ok := scenariosByFeature[uri]
+		if !ok {
+			featureScenarios = &markdownCounts{}
+			scenariosByFeature[uri] = featureScenarios
+			featureNames[uri] = uri
+			if uri == "" {
+				featureNames[uri] = ParseErrorName
+			}
+			features = append(features, uri)
+		}
+		featureScenarios.add(messages.TestStepResultStatus_FAILED)
+	}
+
+	statusHeaders := make([]string, len(markdownStatuses))
+	for index, status := range markdownStatuses {
+		statusHeaders[index] = strings.Title(makeStatus(status))
+	}
+	header := strings.Join(statusHeaders, " | ")
+	separator := strings.Repeat(" ---: |", len(markdownStatuses)+1)
+
+	builder.WriteString("# Cucumber test run\n\n")
+	fmt.Fprintf(&builder, "%d scenarios in %ss\n\n", scenarios.total, formatJUnitDuration(scenarios.duration))
+	fmt.Fprintf(&builder, "| | Total | %s |\n| --- |%s\n", header, separator)
+	fmt.Fprintf(&builder, "| Scenarios | %s |\n", scenarios.cells())
+	fmt.Fprintf(&builder, "| Steps | %s |\n", steps.cells())
+
+	builder.WriteString("\n## Features\n\n")
+	fmt.Fprintf(&builder, "| Feature | Scenarios | %s | Duration |\n| --- |%s ---: |\n", header, separator)
+	for _, uri := range features {
+		name := escapeMarkdown(featureNames[uri])
+		if uri != "" {
+			name += " `" + escapeMarkdown(uri) + "`"
+		}
+		fmt.Fprintf(
+			&builder,
+			"| %s | %s | %ss |\n",
+			name,
+			scenariosByFeature[uri].cells(),
+			formatJUnitDuration(scenariosByFeature[uri].duration),
+		)
+	}
+
+	if len(failedTestCases) > 0 || len(parseErrors) > 0 {
+		builder.WriteString("\n## Failed scenarios\n\n")
+		builder.WriteString("| Scenario | Location | Error |\n| --- | --- | --- |\n")
+		for _, parseError := range parseErrors {
+			uri, line := parseErrorLocation(parseError)
+			// The parse errors without a source have no location
+			location := ""
+			if uri != "" {
+				location = "`" + makeLocation(uri, line) + "`"
+			}
+			fmt.Fprintf(
+				&builder,
+				"| %s | %s | `%s` |\n",
+				ParseErrorName,
+				location,
+				escapeMarkdown(truncateMarkdown(parseError.Message)),
+			)
+		}
+		for _, testCase := range failedTestCases {
+			step := firstStepWithStatus(testCase, messages.TestStepResultStatus_FAILED, messages.TestStepResultStatus_AMBIGUOUS)
+			errorMessage := step.Result.Message
+			// The ambiguous steps seldom have a message of their own
+			if step.Result.Status == messages.TestStepResultStatus_AMBIGUOUS {
+				errorMessage = makeAmbiguousErrorMessage(step, DialectDefault.profile())
+			}
+			message := ""
+			if errorMessage != "" {
+				message = "`" + escapeMarkdown(truncateMarkdown(errorMessage)) + "`"
+			}
+			fmt.Fprintf(
+				&builder,
+				"| %s | `%s` | %s |\n",
+				escapeMarkdown(testCase.Pickle.Name),
+				makeLocation(testCase.Pickle.Uri, testCase.Line()),
+				message,
+			)
+		}
+	}
+
+	if len(testCases) > 0 {
+		slowestTestCases := make([]*TestCase, len(testCases))
+		copy(slowestTestCases, testCases)
+		sort.SliceStable(slowestTestCases, func(i, j int) bool {
+			return testCaseDuration(slowestTestCases[i]) > testCaseDuration(slowestTestCases[j])
+		})
+		if len(slowestTestCases) > markdownSlowestCount {
+			slowestTestCases = slowestTestCases[:markdownSlowestCount]
+		}
+
+		builder.WriteString("\n## Slowest scenarios\n\n")
+		builder.WriteString("| Scenario | Location | Duration |\n| --- | --- | ---: |\n")
+		for _, testCase := range slowestTestCases {
+			fmt.Fprintf(
+				&builder,
+				"| %s | `%s` | %ss |\n",
+				escapeMarkdown(testCase.Pickle.Name),
+				makeLocation(testCase.Pickle.Uri, testCase.Line()),
+				formatJUnitDuration(testCaseDuration(testCase)),
+			)
+		}
+	}
+
+	return builder.String()
+}
+
+// truncateMarkdown keeps the first line of an error message, up to
+// markdownErrorMaxLength characters, so that it fits in a code span
+func truncateMarkdown(message string) string {
+	line := []rune(strings.Replace(strings.TrimSpace(firstLine(message)), "`", "'", -1))
+	if len(line) > markdownErrorMaxLength {
+		return string(line[:markdownErrorMaxLength-1]) + "…"
+	}
+	return string(line)
+}
+
+// escapeMarkdown makes text fit in a table cell
+func escapeMarkdown(text string) string {
+	return strings.NewReplacer("|", "\\|", "\r", "", "\n", " ").Replace(text)
+}
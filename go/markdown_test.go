@@ -0,0 +1,141 @@
+package json
+
+import (
+	"strings"
+
+	"github.com/cucumber/common/messages/go/v18"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("makeMarkdownReport", func() {
+	makeMarkdownTestCase := func(uri string, name string, seconds int64, status messages.TestStepResultStatus, message string) *TestCase {
+		return &TestCase{
+			FeatureName: "Feature " + uri,
+			Scenario:    &messages.Scenario{Location: &messages.Location{Line: 2}},
+			Pickle:      &messages.Pickle{Uri: uri, Name: name, AstNodeIds: []string{"scenario-id"}},
+			Steps: []*TestStep{
+				{
+					Hook:   &messages.Hook{Id: "hook-id"},
+					Result: &messages.TestStepResult{Status: messages.TestStepResultStatus_PASSED, Duration: &messages.Duration{}},
+				},
+				{
+					PickleStep: &messages.PickleStep{Text: "a step"},
+					Result: &messages.TestStepResult{
+						Status:   status,
+						Message:  message,
+						Duration: &messages.Duration{Seconds: seconds},
+					},
+				},
+			},
+		}
+	}
+
+	var testCases []*TestCase
+
+	BeforeEach(func() {
+		testCases = []*TestCase{
+			makeMarkdownTestCase("a.feature", "Scenario A", 1, messages.TestStepResultStatus_PASSED, ""),
+			makeMarkdownTestCase("b.feature", "Scenario B", 3, messages.TestStepResultStatus_FAILED, "expected | 1\n  at steps.go:7"),
+			makeMarkdownTestCase("a.feature", "Scenario C", 2, messages.TestStepResultStatus_UNDEFINED, ""),
+		}
+	})
+
+	It("counts the scenarios and the steps by status", func() {
+		report := makeMarkdownReport(testCases, nil)
+
+		Expect(report).To(ContainSubstring("3 scenarios in 6.000s"))
+		Expect(report).To(ContainSubstring("| | Total | Passed | Failed | Ambiguous | Undefined | Pending | Skipped |\n"))
+		Expect(report).To(ContainSubstring("| Scenarios | 3 | 1 | 1 | 0 | 1 | 0 | 0 |\n"))
+		Expect(report).To(ContainSubstring("| Steps | 3 | 1 | 1 | 0 | 1 | 0 | 0 |\n"))
+	})
+
+	It("breaks the scenarios down by feature", func() {
+		report := makeMarkdownReport(testCases, nil)
+
+		Expect(report).To(ContainSubstring("| Feature a.feature `a.feature` | 2 | 1 | 0 | 0 | 1 | 0 | 0 | 3.000s |\n"))
+		Expect(report).To(ContainSubstring("| Feature b.feature `b.feature` | 1 | 0 | 1 | 0 | 0 | 0 | 0 | 3.000s |\n"))
+	})
+
+	It("lists the failed scenarios with their location and error", func() {
+		report := makeMarkdownReport(testCases, nil)
+
+		Expect(report).To(ContainSubstring("## Failed scenarios"))
+		Expect(report).To(ContainSubstring("| Scenario B | `b.feature:2` | `expected \\| 1` |\n"))
+	})
+
+	It("gives the error of the ambiguous scenarios without a message", func() {
+		ambiguous := makeMarkdownTestCase("c.feature", "Scenario D", 1, messages.TestStepResultStatus_AMBIGUOUS, "")
+
+		report := makeMarkdownReport([]*TestCase{ambiguous}, nil)
+
+		Expect(report).To(ContainSubstring("| Scenario D | `c.feature:2` | `Multiple step definitions match \"a step\":` |\n"))
+	})
+
+	It("lists the slowest scenarios first", func() {
+		report := makeMarkdownReport(testCases, nil)
+
+		slowest := report[strings.Index(report, "## Slowest scenarios"):]
+		Expect(strings.Index(slowest, "Scenario B")).To(BeNumerically("<", strings.Index(slowest, "Scenario C")))
+		Expect(strings.Index(slowest, "Scenario C")).To(BeNumerically("<", strings.Index(slowest, "Scenario A")))
+	})
+
+	It("only summarises the final attempt of the retried scenarios", func() {
+		output := &strings.Builder{}
+		reporter, err := newMarkdownReporter(&Formatter{}, output)
+		Expect(err).To(BeNil())
+		failed := makeMarkdownTestCase("a.feature", "Scenario A", 1, messages.TestStepResultStatus_FAILED, "flaky")
+		failed.WillBeRetried = true
+		passed := makeMarkdownTestCase("a.feature", "Scenario A", 1, messages.TestStepResultStatus_PASSED, "")
+		passed.Attempt = 1
+
+		Expect(reporter.TestCaseFinished(failed)).To(Succeed())
+		Expect(reporter.TestCaseFinished(passed)).To(Succeed())
+		Expect(reporter.TestRunFinished(&TestRun{})).To(Succeed())
+
+		Expect(output.String()).To(ContainSubstring("| Scenarios | 1 | 1 | 0 | 0 | 0 | 0 | 0 |\n"))
+		Expect(output.String()).NotTo(ContainSubstring("## Failed scenarios"))
+	})
+
+	It("reports the feature files which can not be parsed as failed scenarios", func() {
+		parseErrors := []*messages.ParseError{
+			{
+				Source:  &messages.SourceReference{Uri: "c.feature", Location: &messages.Location{Line: 3}},
+				Message: "(3:1): expected: #TagLine, got 'Scenari: C'",
+			},
+		}
+
+		report := makeMarkdownReport(testCases, parseErrors)
+
+		Expect(report).To(ContainSubstring("| Scenarios | 4 | 1 | 2 | 0 | 1 | 0 | 0 |\n"))
+		Expect(report).To(ContainSubstring("| c.feature `c.feature` | 1 | 0 | 1 | 0 | 0 | 0 | 0 | 0.000s |\n"))
+		Expect(report).To(ContainSubstring("| Parse error | `c.feature:3` | `(3:1): expected: #TagLine, got 'Scenari: C'` |\n"))
+	})
+
+	It("reports the parse errors without a source without a location", func() {
+		parseErrors := []*messages.ParseError{{Message: "no source"}}
+
+		report := makeMarkdownReport(nil, parseErrors)
+
+		Expect(report).To(ContainSubstring("| Parse error | 1 | 0 | 1 | 0 | 0 | 0 | 0 | 0.000s |\n"))
+		Expect(report).To(ContainSubstring("| Parse error |  | `no source` |\n"))
+	})
+
+	It("does not list failed scenarios when there are none", func() {
+		report := makeMarkdownReport(testCases[:1], nil)
+
+		Expect(report).NotTo(ContainSubstring("## Failed scenarios"))
+	})
+})
+
+var _ = Describe("truncateMarkdown", func() {
+	It("keeps the first line of the message", func() {
+		Expect(truncateMarkdown("boom\nat steps.go:7")).To(Equal("boom"))
+	})
+
+	It("truncates the long messages", func() {
+		truncated := truncateMarkdown(strings.Repeat("é", markdownErrorMaxLength+1))
+		Expect([]rune(truncated)).To(HaveLen(markdownErrorMaxLength))
+		Expect(truncated).To(HaveSuffix("…"))
+	})
+})
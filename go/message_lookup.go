@@ -2,27 +2,35 @@ package json
 
 import (
 	"fmt"
+	"log"
+	"os"
 
 	"github.com/cucumber/common/messages/go/v18"
 )
 
 type MessageLookup struct {
-	gherkinDocumentByURI    map[string]*messages.GherkinDocument
-	pickleByID              map[string]*messages.Pickle
-	pickleStepByID          map[string]*messages.PickleStep
-	testCaseByID            map[string]*messages.TestCase
-	testStepByID            map[string]*messages.TestStep
-	testCaseStartedByID     map[string]*messages.TestCaseStarted
-	stepByID                map[string]*messages.Step
-	scenarioByID            map[string]*messages.Scenario
-	exampleByRowID          map[string]*messages.Examples
-	exampleRowByID          map[string]*messages.TableRow
-	stepDefinitionByID      map[string]*messages.StepDefinition
-	backgroundByStepID      map[string]*messages.Background
-	tagByID                 map[string]*messages.Tag
-	hookByID                map[string]*messages.Hook
-	attachmentsByTestStepID map[string][]*messages.Attachment
-	verbose                 bool
+	gherkinDocumentByURI           map[string]*messages.GherkinDocument
+	pickleByID                     map[string]*messages.Pickle
+	pickleStepByID                 map[string]*messages.PickleStep
+	testCaseByID                   map[string]*messages.TestCase
+	testStepByID                   map[string]*messages.TestStep
+	testCaseStartedByID            map[string]*messages.TestCaseStarted
+	testStepStartedByID            map[string]map[string]*messages.TestStepStarted
+	stepByID                       map[string]*messages.Step
+	scenarioByID                   map[string]*messages.Scenario
+	ruleByScenarioID               map[string]*messages.Rule
+	exampleByRowID                 map[string]*messages.Examples
+	exampleRowByID                 map[string]*messages.TableRow
+	stepDefinitionByID             map[string]*messages.StepDefinition
+	backgroundByStepID             map[string]*messages.Background
+	tagByID                        map[string]*messages.Tag
+	hookByID                       map[string]*messages.Hook
+	attachmentsByTestStepID        map[string][]*messages.Attachment
+	attachmentsByTestCaseStartedID map[string][]*messages.Attachment
+	runAttachments                 []*messages.Attachment
+	scope                          string
+	verbose                        bool
+	logger                         *log.Logger
 }
 
 func (ml *MessageLookup) Initialize(verbose bool) {
@@ -32,8 +40,10 @@ func (ml *MessageLookup) Initialize(verbose bool) {
 	ml.testCaseByID = make(map[string]*messages.TestCase)
 	ml.testStepByID = make(map[string]*messages.TestStep)
 	ml.testCaseStartedByID = make(map[string]*messages.TestCaseStarted)
+	ml.testStepStartedByID = make(map[string]map[string]*messages.TestStepStarted)
 	ml.stepByID = make(map[string]*messages.Step)
 	ml.scenarioByID = make(map[string]*messages.Scenario)
+	ml.ruleByScenarioID = make(map[string]*messages.Rule)
 	ml.exampleByRowID = make(map[string]*messages.Examples)
 	ml.exampleRowByID = make(map[string]*messages.TableRow)
 	ml.stepDefinitionByID = make(map[string]*messages.StepDefinition)
@@ -41,81 +51,178 @@ func (ml *MessageLookup) Initialize(verbose bool) {
 	ml.tagByID = make(map[string]*messages.Tag)
 	ml.hookByID = make(map[string]*messages.Hook)
 	ml.attachmentsByTestStepID = make(map[string][]*messages.Attachment)
+	ml.attachmentsByTestCaseStartedID = make(map[string][]*messages.Attachment)
+	ml.runAttachments = make([]*messages.Attachment, 0)
 
+	ml.scope = ""
 	ml.verbose = verbose
+	ml.logger = log.New(os.Stderr, "", 0)
+}
+
+// SetLogger sets where the lookup hits and misses are written to in verbose
+// mode (STDERR by default)
+func (ml *MessageLookup) SetLogger(logger *log.Logger) {
+	ml.logger = logger
+}
+
+// SetScope namespaces the IDs of the messages processed from now on, so that
+// several message streams using the same IDs can share one MessageLookup.
+func (ml *MessageLookup) SetScope(scope string) {
+	ml.scope = scope
+}
+
+func (ml *MessageLookup) key(id string) string {
+	return ml.scope + id
 }
 
 func (ml *MessageLookup) ProcessMessage(envelope *messages.Envelope) (err error) {
 	if envelope.GherkinDocument != nil {
-		ml.gherkinDocumentByURI[envelope.GherkinDocument.Uri] = envelope.GherkinDocument
+		ml.gherkinDocumentByURI[ml.key(envelope.GherkinDocument.Uri)] = envelope.GherkinDocument
 		ml.comment(fmt.Sprintf("Stored GherkinDocument: %s", envelope.GherkinDocument.Uri))
 		for key := range ml.gherkinDocumentByURI {
 			ml.comment(fmt.Sprintf(" - %s ", key))
 		}
-		if envelope.GherkinDocument.Feature == nil {
-			return nil
-		}
-		ml.processTags(envelope.GherkinDocument.Feature.Tags)
-
-		for _, child := range envelope.GherkinDocument.Feature.Children {
-			ml.processRule(child.Rule)
-			ml.processBackground(child.Background)
-			ml.processScenario(child.Scenario)
+		// A feature file which could not be parsed has no Feature: its errors
+		// come in ParseError messages
+		if envelope.GherkinDocument.Feature != nil {
+			ml.processTags(envelope.GherkinDocument.Feature.Tags)
+
+			for _, child := range envelope.GherkinDocument.Feature.Children {
+				ml.processRule(child.Rule)
+				ml.processBackground(child.Background)
+				ml.processScenario(child.Scenario)
+			}
 		}
 	}
 
 	if envelope.Pickle != nil {
-		ml.pickleByID[envelope.Pickle.Id] = envelope.Pickle
+		ml.pickleByID[ml.key(envelope.Pickle.Id)] = envelope.Pickle
 
 		for _, step := range envelope.Pickle.Steps {
-			ml.pickleStepByID[step.Id] = step
+			ml.pickleStepByID[ml.key(step.Id)] = step
 		}
 	}
 
 	if envelope.TestCase != nil {
-		ml.testCaseByID[envelope.TestCase.Id] = envelope.TestCase
+		ml.testCaseByID[ml.key(envelope.TestCase.Id)] = envelope.TestCase
 
 		for _, step := range envelope.TestCase.TestSteps {
-			ml.testStepByID[step.Id] = step
+			ml.testStepByID[ml.key(step.Id)] = step
 		}
 	}
 
 	if envelope.TestCaseStarted != nil {
-		ml.testCaseStartedByID[envelope.TestCaseStarted.Id] = envelope.TestCaseStarted
+		ml.testCaseStartedByID[ml.key(envelope.TestCaseStarted.Id)] = envelope.TestCaseStarted
 	}
 
-	if envelope.Attachment != nil {
-		attachments, ok := ml.attachmentsByTestStepID[envelope.Attachment.TestStepId]
+	if envelope.TestStepStarted != nil {
+		testCaseStartedKey := ml.key(envelope.TestStepStarted.TestCaseStartedId)
+		testStepsStarted, ok := ml.testStepStartedByID[testCaseStartedKey]
 		if !ok {
-			attachments = make([]*messages.Attachment, 0)
-			ml.attachmentsByTestStepID[envelope.Attachment.TestStepId] = attachments
+			testStepsStarted = make(map[string]*messages.TestStepStarted)
+			ml.testStepStartedByID[testCaseStartedKey] = testStepsStarted
 		}
-		attachments = append(attachments, envelope.Attachment)
-		ml.attachmentsByTestStepID[envelope.Attachment.TestStepId] = attachments
+		testStepsStarted[envelope.TestStepStarted.TestStepId] = envelope.TestStepStarted
+	}
+
+	if envelope.Attachment != nil {
+		ml.processAttachment(envelope.Attachment)
 	}
 
 	if envelope.StepDefinition != nil {
-		ml.stepDefinitionByID[envelope.StepDefinition.Id] = envelope.StepDefinition
+		ml.stepDefinitionByID[ml.key(envelope.StepDefinition.Id)] = envelope.StepDefinition
 	}
 
 	if envelope.Hook != nil {
-		ml.hookByID[envelope.Hook.Id] = envelope.Hook
+		ml.hookByID[ml.key(envelope.Hook.Id)] = envelope.Hook
 	}
 
 	return nil
 }
 
+// EvictTestCaseStarted forgets the messages which are no longer needed once a
+// test case attempt finished: the attempt and its attachments and, unless the
+// test case will be retried, the test case and its pickle.
+func (ml *MessageLookup) EvictTestCaseStarted(testCaseFinished *messages.TestCaseFinished) {
+	testCaseStartedKey := ml.key(testCaseFinished.TestCaseStartedId)
+	testCaseStarted, ok := ml.testCaseStartedByID[testCaseStartedKey]
+	if !ok {
+		return
+	}
+	delete(ml.testCaseStartedByID, testCaseStartedKey)
+	delete(ml.testStepStartedByID, testCaseStartedKey)
+	delete(ml.attachmentsByTestCaseStartedID, testCaseStartedKey)
+	ml.comment(fmt.Sprintf("Evicted TestCaseStarted: %s", testCaseFinished.TestCaseStartedId))
+
+	testCaseKey := ml.key(testCaseStarted.TestCaseId)
+	testCase, ok := ml.testCaseByID[testCaseKey]
+	if !ok {
+		return
+	}
+	for _, testStep := range testCase.TestSteps {
+		delete(ml.attachmentsByTestStepID, ml.key(testStep.Id))
+	}
+	if testCaseFinished.WillBeRetried {
+		return
+	}
+
+	for _, testStep := range testCase.TestSteps {
+		delete(ml.testStepByID, ml.key(testStep.Id))
+	}
+	delete(ml.testCaseByID, testCaseKey)
+
+	pickleKey := ml.key(testCase.PickleId)
+	if pickle, ok := ml.pickleByID[pickleKey]; ok {
+		for _, step := range pickle.Steps {
+			delete(ml.pickleStepByID, ml.key(step.Id))
+		}
+		delete(ml.pickleByID, pickleKey)
+	}
+	ml.comment(fmt.Sprintf("Evicted TestCase: %s", testCaseStarted.TestCaseId))
+}
+
+func (ml *MessageLookup) processAttachment(attachment *messages.Attachment) {
+	// The test case attempt already finished, and was reported without it
+	if attachment.TestCaseStartedId != "" {
+		if _, ok := ml.testCaseStartedByID[ml.key(attachment.TestCaseStartedId)]; !ok {
+			ml.comment(fmt.Sprintf("Attachment of a finished TestCaseStarted: %s", attachment.TestCaseStartedId))
+			ml.runAttachments = append(ml.runAttachments, attachment)
+			return
+		}
+	}
+
+	if attachment.TestStepId != "" {
+		testStepKey := ml.key(attachment.TestStepId)
+		ml.attachmentsByTestStepID[testStepKey] = append(ml.attachmentsByTestStepID[testStepKey], attachment)
+		return
+	}
+
+	if attachment.TestCaseStartedId != "" {
+		testCaseStartedKey := ml.key(attachment.TestCaseStartedId)
+		ml.attachmentsByTestCaseStartedID[testCaseStartedKey] = append(ml.attachmentsByTestCaseStartedID[testCaseStartedKey], attachment)
+		return
+	}
+
+	ml.runAttachments = append(ml.runAttachments, attachment)
+}
+
 func (ml *MessageLookup) processTags(tags []*messages.Tag) {
 	for _, tag := range tags {
-		ml.tagByID[tag.Id] = tag
+		ml.tagByID[ml.key(tag.Id)] = tag
 	}
 }
 
 func (ml *MessageLookup) processRule(rule *messages.Rule) {
 	if rule != nil {
+		ml.processTags(rule.Tags)
+
 		for _, ruleChild := range rule.Children {
 			ml.processBackground(ruleChild.Background)
 			ml.processScenario(ruleChild.Scenario)
+
+			if ruleChild.Scenario != nil {
+				ml.ruleByScenarioID[ml.key(ruleChild.Scenario.Id)] = rule
+			}
 		}
 	}
 }
@@ -123,19 +230,19 @@ func (ml *MessageLookup) processRule(rule *messages.Rule) {
 func (ml *MessageLookup) processBackground(background *messages.Background) {
 	if background != nil {
 		for _, step := range background.Steps {
-			ml.backgroundByStepID[step.Id] = background
-			ml.stepByID[step.Id] = step
+			ml.backgroundByStepID[ml.key(step.Id)] = background
+			ml.stepByID[ml.key(step.Id)] = step
 		}
 	}
 }
 
 func (ml *MessageLookup) processScenario(scenario *messages.Scenario) {
 	if scenario != nil {
-		ml.scenarioByID[scenario.Id] = scenario
+		ml.scenarioByID[ml.key(scenario.Id)] = scenario
 		ml.processTags(scenario.Tags)
 
 		for _, step := range scenario.Steps {
-			ml.stepByID[step.Id] = step
+			ml.stepByID[ml.key(step.Id)] = step
 		}
 
 		for _, example := range scenario.Examples {
@@ -143,15 +250,15 @@ func (ml *MessageLookup) processScenario(scenario *messages.Scenario) {
 
 			for _, row := range example.TableBody {
 				// TODO: we may also need to add IDs to the examples
-				ml.exampleByRowID[row.Id] = example
-				ml.exampleRowByID[row.Id] = row
+				ml.exampleByRowID[ml.key(row.Id)] = example
+				ml.exampleRowByID[ml.key(row.Id)] = row
 			}
 		}
 	}
 }
 
 func (ml *MessageLookup) LookupGherkinDocument(uri string) *messages.GherkinDocument {
-	item, ok := ml.gherkinDocumentByURI[uri]
+	item, ok := ml.gherkinDocumentByURI[ml.key(uri)]
 	if ok {
 		ml.informFoundKey(uri, "gherkinDocumentByURI")
 	} else {
@@ -161,7 +268,7 @@ func (ml *MessageLookup) LookupGherkinDocument(uri string) *messages.GherkinDocu
 }
 
 func (ml *MessageLookup) LookupScenario(id string) *messages.Scenario {
-	item, ok := ml.scenarioByID[id]
+	item, ok := ml.scenarioByID[ml.key(id)]
 	if ok {
 		ml.informFoundKey(id, "scenarioByID")
 	} else {
@@ -170,8 +277,18 @@ func (ml *MessageLookup) LookupScenario(id string) *messages.Scenario {
 	return item
 }
 
+func (ml *MessageLookup) LookupRuleByScenarioID(id string) *messages.Rule {
+	item, ok := ml.ruleByScenarioID[ml.key(id)]
+	if ok {
+		ml.informFoundKey(id, "ruleByScenarioID")
+	} else {
+		ml.informMissingKey(id, "ruleByScenarioID")
+	}
+	return item
+}
+
 func (ml *MessageLookup) LookupPickle(id string) *messages.Pickle {
-	item, ok := ml.pickleByID[id]
+	item, ok := ml.pickleByID[ml.key(id)]
 	if ok {
 		ml.informFoundKey(id, "pickleByID")
 	} else {
@@ -181,7 +298,7 @@ func (ml *MessageLookup) LookupPickle(id string) *messages.Pickle {
 }
 
 func (ml *MessageLookup) LookupStep(id string) *messages.Step {
-	item, ok := ml.stepByID[id]
+	item, ok := ml.stepByID[ml.key(id)]
 	if ok {
 		ml.informFoundKey(id, "stepByID")
 	} else {
@@ -191,7 +308,7 @@ func (ml *MessageLookup) LookupStep(id string) *messages.Step {
 }
 
 func (ml *MessageLookup) LookupExample(id string) *messages.Examples {
-	item, ok := ml.exampleByRowID[id]
+	item, ok := ml.exampleByRowID[ml.key(id)]
 	if ok {
 		ml.informFoundKey(id, "exampleByRowID")
 	} else {
@@ -201,7 +318,7 @@ func (ml *MessageLookup) LookupExample(id string) *messages.Examples {
 }
 
 func (ml *MessageLookup) LookupExampleRow(id string) *messages.TableRow {
-	item, ok := ml.exampleRowByID[id]
+	item, ok := ml.exampleRowByID[ml.key(id)]
 	if ok {
 		ml.informFoundKey(id, "exampleRowByID")
 	} else {
@@ -211,7 +328,7 @@ func (ml *MessageLookup) LookupExampleRow(id string) *messages.TableRow {
 }
 
 func (ml *MessageLookup) LookupBackgroundByStepID(id string) *messages.Background {
-	item, ok := ml.backgroundByStepID[id]
+	item, ok := ml.backgroundByStepID[ml.key(id)]
 	if ok {
 		ml.informFoundKey(id, "backgroundByStepID")
 	} else {
@@ -221,7 +338,7 @@ func (ml *MessageLookup) LookupBackgroundByStepID(id string) *messages.Backgroun
 }
 
 func (ml *MessageLookup) LookupTag(id string) *messages.Tag {
-	item, ok := ml.tagByID[id]
+	item, ok := ml.tagByID[ml.key(id)]
 	if ok {
 		ml.informFoundKey(id, "tagByID")
 	} else {
@@ -231,7 +348,7 @@ func (ml *MessageLookup) LookupTag(id string) *messages.Tag {
 }
 
 func (ml *MessageLookup) LookupTestCaseStarted(id string) *messages.TestCaseStarted {
-	item, ok := ml.testCaseStartedByID[id]
+	item, ok := ml.testCaseStartedByID[ml.key(id)]
 	if ok {
 		ml.informFoundKey(id, "testCaseStartedByID")
 	} else {
@@ -240,8 +357,20 @@ func (ml *MessageLookup) LookupTestCaseStarted(id string) *messages.TestCaseStar
 	return item
 }
 
+// LookupTestStepStarted returns when the test step started within the given
+// attempt of its test case, as a test step runs once per attempt
+func (ml *MessageLookup) LookupTestStepStarted(testCaseStartedId string, testStepId string) *messages.TestStepStarted {
+	item, ok := ml.testStepStartedByID[ml.key(testCaseStartedId)][testStepId]
+	if ok {
+		ml.informFoundKey(testStepId, "testStepStartedByID")
+	} else {
+		ml.informMissingKey(testStepId, "testStepStartedByID")
+	}
+	return item
+}
+
 func (ml *MessageLookup) LookupTestCase(id string) *messages.TestCase {
-	item, ok := ml.testCaseByID[id]
+	item, ok := ml.testCaseByID[ml.key(id)]
 	if ok {
 		ml.informFoundKey(id, "testCaseByID")
 	} else {
@@ -251,7 +380,7 @@ func (ml *MessageLookup) LookupTestCase(id string) *messages.TestCase {
 }
 
 func (ml *MessageLookup) LookupTestStep(id string) *messages.TestStep {
-	item, ok := ml.testStepByID[id]
+	item, ok := ml.testStepByID[ml.key(id)]
 	if ok {
 		ml.informFoundKey(id, "testStepByID")
 	} else {
@@ -261,7 +390,7 @@ func (ml *MessageLookup) LookupTestStep(id string) *messages.TestStep {
 }
 
 func (ml *MessageLookup) LookupPickleStep(id string) *messages.PickleStep {
-	item, ok := ml.pickleStepByID[id]
+	item, ok := ml.pickleStepByID[ml.key(id)]
 	if ok {
 		ml.informFoundKey(id, "pickleStepByID")
 	} else {
@@ -279,7 +408,7 @@ func (ml *MessageLookup) LookupStepDefinitions(ids []string) []*messages.StepDef
 }
 
 func (ml *MessageLookup) LookupStepDefinition(id string) *messages.StepDefinition {
-	item, ok := ml.stepDefinitionByID[id]
+	item, ok := ml.stepDefinitionByID[ml.key(id)]
 	if ok {
 		ml.informFoundKey(id, "stepDefinitionByID")
 	} else {
@@ -289,7 +418,7 @@ func (ml *MessageLookup) LookupStepDefinition(id string) *messages.StepDefinitio
 }
 
 func (ml *MessageLookup) LookupHook(id string) *messages.Hook {
-	item, ok := ml.hookByID[id]
+	item, ok := ml.hookByID[ml.key(id)]
 	if ok {
 		ml.informFoundKey(id, "hookByID")
 	} else {
@@ -299,7 +428,7 @@ func (ml *MessageLookup) LookupHook(id string) *messages.Hook {
 }
 
 func (ml *MessageLookup) LookupAttachments(testStepId string) []*messages.Attachment {
-	item, ok := ml.attachmentsByTestStepID[testStepId]
+	item, ok := ml.attachmentsByTestStepID[ml.key(testStepId)]
 	if ok {
 		ml.informFoundKey(testStepId, "attachmentsByTestStepID")
 	} else {
@@ -308,6 +437,24 @@ func (ml *MessageLookup) LookupAttachments(testStepId string) []*messages.Attach
 	return item
 }
 
+// LookupTestCaseAttachments returns the attachments of a test case attempt which
+// are not attached to one of its steps
+func (ml *MessageLookup) LookupTestCaseAttachments(testCaseStartedId string) []*messages.Attachment {
+	item, ok := ml.attachmentsByTestCaseStartedID[ml.key(testCaseStartedId)]
+	if ok {
+		ml.informFoundKey(testCaseStartedId, "attachmentsByTestCaseStartedID")
+	} else {
+		ml.informMissingKey(testCaseStartedId, "attachmentsByTestCaseStartedID")
+	}
+	return item
+}
+
+// LookupRunAttachments returns the attachments which are not attached to a
+// test case, e.g. those of the hooks running before or after all the tests
+func (ml *MessageLookup) LookupRunAttachments() []*messages.Attachment {
+	return ml.runAttachments
+}
+
 func (ml *MessageLookup) informFoundKey(key string, mapName string) {
 	ml.comment(fmt.Sprintf("Found item'%s' in %s", key, mapName))
 }
@@ -318,6 +465,6 @@ func (ml *MessageLookup) informMissingKey(key string, mapName string) {
 
 func (ml *MessageLookup) comment(message string) {
 	if ml.verbose {
-		fmt.Println(fmt.Sprintf("// LookUp: %s", message))
+		ml.logger.Printf("// LookUp: %s", message)
 	}
 }
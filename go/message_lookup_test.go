@@ -1,6 +1,9 @@
 package json
 
 import (
+	"bytes"
+	"log"
+
 	"github.com/cucumber/common/messages/go/v18"
 
 	. "github.com/onsi/ginkgo"
@@ -49,4 +52,169 @@ var _ = Describe("MessageLookup", func() {
 			})
 		})
 	})
+
+	Context("MessageLookup.processRule", func() {
+		var (
+			tag      messages.Tag
+			scenario messages.Scenario
+			rule     messages.Rule
+		)
+
+		BeforeEach(func() {
+			tag = messages.Tag{
+				Id:   "rule-tag-id",
+				Name: "@rule-tag",
+			}
+			scenario = messages.Scenario{
+				Id: "scenario-id",
+			}
+			rule = messages.Rule{
+				Name: "a rule",
+				Tags: []*messages.Tag{&tag},
+				Children: []*messages.RuleChild{
+					{Scenario: &scenario},
+				},
+			}
+		})
+
+		It("processes rule's tags", func() {
+			ml.processRule(&rule)
+			Expect(ml.LookupTag("rule-tag-id")).To(Equal(&tag))
+		})
+
+		It("processes rule's scenarios", func() {
+			ml.processRule(&rule)
+			Expect(ml.LookupScenario("scenario-id")).To(Equal(&scenario))
+		})
+
+		It("knows the rule of the scenarios", func() {
+			ml.processRule(&rule)
+			Expect(ml.LookupRuleByScenarioID("scenario-id")).To(Equal(&rule))
+		})
+	})
+
+	Context("MessageLookup.SetLogger", func() {
+		It("logs the lookup hits and misses to the logger", func() {
+			logs := &bytes.Buffer{}
+			ml.SetLogger(log.New(logs, "", 0))
+
+			ml.LookupPickle("unknown-pickle-id")
+
+			Expect(logs.String()).To(Equal("// LookUp: Unable to find 'unknown-pickle-id' in pickleByID\n"))
+		})
+
+		It("does not log anything when not verbose", func() {
+			logs := &bytes.Buffer{}
+			ml.Initialize(false)
+			ml.SetLogger(log.New(logs, "", 0))
+
+			ml.LookupPickle("unknown-pickle-id")
+
+			Expect(logs.String()).To(BeEmpty())
+		})
+	})
+
+	Context("MessageLookup.SetScope", func() {
+		It("keeps apart the messages having the same ID in different scopes", func() {
+			first := &messages.Pickle{Id: "pickle-id", Name: "first"}
+			second := &messages.Pickle{Id: "pickle-id", Name: "second"}
+
+			ml.SetScope("1:")
+			ml.ProcessMessage(makePickleEnvelope(first))
+			ml.SetScope("2:")
+			ml.ProcessMessage(makePickleEnvelope(second))
+
+			Expect(ml.LookupPickle("pickle-id")).To(Equal(second))
+			ml.SetScope("1:")
+			Expect(ml.LookupPickle("pickle-id")).To(Equal(first))
+		})
+	})
+
+	Context("MessageLookup.ProcessMessage with an Attachment", func() {
+		processAttachment := func(attachment *messages.Attachment) {
+			ml.ProcessMessage(&messages.Envelope{Attachment: attachment})
+		}
+
+		JustBeforeEach(func() {
+			ml.ProcessMessage(makeTestCaseStartedEnvelope(&messages.TestCaseStarted{
+				Id:         "test-case-started-id",
+				TestCaseId: "test-case-id",
+			}))
+		})
+
+		It("indexes the attachments of a step by TestStepId", func() {
+			attachment := &messages.Attachment{TestCaseStartedId: "test-case-started-id", TestStepId: "test-step-id"}
+			processAttachment(attachment)
+
+			Expect(ml.LookupAttachments("test-step-id")).To(Equal([]*messages.Attachment{attachment}))
+			Expect(ml.LookupTestCaseAttachments("test-case-started-id")).To(BeEmpty())
+			Expect(ml.LookupRunAttachments()).To(BeEmpty())
+		})
+
+		It("indexes the attachments of a test case by TestCaseStartedId", func() {
+			attachment := &messages.Attachment{TestCaseStartedId: "test-case-started-id"}
+			processAttachment(attachment)
+
+			Expect(ml.LookupAttachments("")).To(BeEmpty())
+			Expect(ml.LookupTestCaseAttachments("test-case-started-id")).To(Equal([]*messages.Attachment{attachment}))
+			Expect(ml.LookupRunAttachments()).To(BeEmpty())
+		})
+
+		It("keeps the attachments of the run", func() {
+			attachment := &messages.Attachment{Body: "some log"}
+			processAttachment(attachment)
+
+			Expect(ml.LookupAttachments("")).To(BeEmpty())
+			Expect(ml.LookupRunAttachments()).To(Equal([]*messages.Attachment{attachment}))
+		})
+
+		It("keeps the attachments of a finished test case with the attachments of the run", func() {
+			ml.EvictTestCaseStarted(&messages.TestCaseFinished{TestCaseStartedId: "test-case-started-id"})
+			attachment := &messages.Attachment{TestCaseStartedId: "test-case-started-id", TestStepId: "test-step-id"}
+			processAttachment(attachment)
+
+			Expect(ml.LookupAttachments("test-step-id")).To(BeEmpty())
+			Expect(ml.LookupTestCaseAttachments("test-case-started-id")).To(BeEmpty())
+			Expect(ml.LookupRunAttachments()).To(Equal([]*messages.Attachment{attachment}))
+		})
+	})
+
+	Context("MessageLookup.EvictTestCaseStarted", func() {
+		JustBeforeEach(func() {
+			envelopes := makeScenarioARun(messages.TestStepResultStatus_PASSED)
+			envelopes = append(envelopes, &messages.Envelope{
+				Attachment: &messages.Attachment{
+					TestStepId: "test-step-id",
+					Body:       "some data",
+				},
+			})
+			for _, envelope := range envelopes {
+				ml.ProcessMessage(envelope)
+			}
+		})
+
+		It("forgets the finished test case, its pickle and its attachments", func() {
+			ml.EvictTestCaseStarted(&messages.TestCaseFinished{TestCaseStartedId: "test-case-started-id"})
+
+			Expect(ml.LookupTestCaseStarted("test-case-started-id")).To(BeNil())
+			Expect(ml.LookupTestCase("test-case-id")).To(BeNil())
+			Expect(ml.LookupTestStep("test-step-id")).To(BeNil())
+			Expect(ml.LookupPickle("pickle-id")).To(BeNil())
+			Expect(ml.LookupPickleStep("pickle-step-id")).To(BeNil())
+			Expect(ml.LookupAttachments("test-step-id")).To(BeNil())
+			Expect(ml.LookupGherkinDocument("a.feature")).NotTo(BeNil())
+		})
+
+		It("keeps the test case which will be retried", func() {
+			ml.EvictTestCaseStarted(&messages.TestCaseFinished{
+				TestCaseStartedId: "test-case-started-id",
+				WillBeRetried:     true,
+			})
+
+			Expect(ml.LookupTestCaseStarted("test-case-started-id")).To(BeNil())
+			Expect(ml.LookupTestCase("test-case-id")).NotTo(BeNil())
+			Expect(ml.LookupPickle("pickle-id")).NotTo(BeNil())
+			Expect(ml.LookupAttachments("test-step-id")).To(BeNil())
+		})
+	})
 })
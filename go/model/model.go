@@ -0,0 +1,153 @@
+// Package model is the Cucumber JSON report, as written by the formatter.
+//
+// It covers the fields of the JSON of cucumber-ruby, cucumber-jvm and
+// cucumber-js, so that the reports of any of them can be read with Decode.
+package model
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Feature is a feature file, with the elements which ran
+type Feature struct {
+	Description string     `json:"description"`
+	Elements    []*Element `json:"elements"`
+	ID          string     `json:"id"`
+	Keyword     string     `json:"keyword"`
+	Line        uint32     `json:"line"`
+	Name        string     `json:"name"`
+	URI         string     `json:"uri"`
+	Tags        []*Tag     `json:"tags,omitempty"`
+}
+
+// Element is a background or a scenario (type "background" or "scenario")
+type Element struct {
+	Description    string       `json:"description"`
+	ID             string       `json:"id,omitempty"`
+	Keyword        string       `json:"keyword"`
+	Line           uint32       `json:"line"`
+	Name           string       `json:"name"`
+	Before         []*Step      `json:"before,omitempty"`
+	Steps          []*Step      `json:"steps"`
+	After          []*Step      `json:"after,omitempty"`
+	Type           string       `json:"type"`
+	Tags           []*Tag       `json:"tags,omitempty"`
+	Attempt        int64        `json:"attempt,omitempty"`
+	StartTimestamp string       `json:"start_timestamp,omitempty"`
+	Embeddings     []*Embedding `json:"embeddings,omitempty"`
+	Output         []string     `json:"output,omitempty"`
+}
+
+// Step is a step or a hook, which has no keyword nor name (except in the
+// hidden hook steps of cucumber-js)
+type Step struct {
+	Keyword        string          `json:"keyword,omitempty"`
+	Line           uint32          `json:"line,omitempty"`
+	Name           string          `json:"name,omitempty"`
+	Result         *StepResult     `json:"result"`
+	Match          *StepMatch      `json:"match,omitempty"`
+	DocString      *DocString      `json:"doc_string,omitempty"`
+	Rows           []*DatatableRow `json:"rows,omitempty"`
+	Arguments      []*StepArgument `json:"arguments,omitempty"`
+	Embeddings     []*Embedding    `json:"embeddings,omitempty"`
+	Output         []string        `json:"output,omitempty"`
+	Before         []*Step         `json:"before,omitempty"`
+	After          []*Step         `json:"after,omitempty"`
+	StartTimestamp string          `json:"start_timestamp,omitempty"`
+	Hidden         bool            `json:"hidden,omitempty"`
+}
+
+// DocString is the DocString of a step, at Line in the feature file
+type DocString struct {
+	ContentType string `json:"content_type"`
+	Line        uint32 `json:"line"`
+	Value       string `json:"value"`
+}
+
+// DatatableRow is a row of the DataTable of a step
+type DatatableRow struct {
+	Cells []string `json:"cells"`
+}
+
+// StepArgument is the DocString (Content) or DataTable (Rows) of a step, as
+// cucumber-js gives them
+type StepArgument struct {
+	Content string          `json:"content,omitempty"`
+	Line    uint32          `json:"line,omitempty"`
+	Rows    []*DatatableRow `json:"rows,omitempty"`
+}
+
+// StepResult has the Duration in nanoseconds
+type StepResult struct {
+	Duration     uint64 `json:"duration,omitempty"`
+	Status       string `json:"status"`
+	ErrorMessage string `json:"error_message,omitempty"`
+}
+
+// StepMatch is the location of the step definition or hook which ran
+type StepMatch struct {
+	Location  string      `json:"location"`
+	Arguments []*Argument `json:"arguments,omitempty"`
+}
+
+// Argument is a value captured by a step definition, at Offset in the step text
+type Argument struct {
+	Val      string      `json:"val"`
+	Offset   int64       `json:"offset"`
+	Children []*Argument `json:"children,omitempty"`
+}
+
+// Tag is a tag of a feature or a scenario, at Line in the feature file
+type Tag struct {
+	Line uint32 `json:"line"`
+	Name string `json:"name"`
+}
+
+// Embedding is an attachment, either inline in base64 (Data) or written to a
+// file (Path)
+type Embedding struct {
+	Data     string `json:"data"`
+	MimeType string `json:"mime_type"`
+	Name     string `json:"name,omitempty"`
+	Path     string `json:"path,omitempty"`
+}
+
+// fileEmbedding is an Embedding written to a file, which has no data
+type fileEmbedding struct {
+	Data     string `json:"data,omitempty"`
+	MimeType string `json:"mime_type"`
+	Name     string `json:"name,omitempty"`
+	Path     string `json:"path"`
+}
+
+// MarshalJSON always writes the data, even when empty, as cucumber-ruby does,
+// unless the embedding was written to a file
+func (self Embedding) MarshalJSON() ([]byte, error) {
+	if self.Path != "" {
+		return json.Marshal(fileEmbedding(self))
+	}
+	// embedding has the fields of Embedding without its MarshalJSON
+	type embedding Embedding
+	return json.Marshal(embedding(self))
+}
+
+// Unmarshal reads a Cucumber JSON report
+func Unmarshal(data []byte) ([]*Feature, error) {
+	features := make([]*Feature, 0)
+	err := json.Unmarshal(data, &features)
+	if err != nil {
+		return nil, err
+	}
+	return features, nil
+}
+
+// Decode reads a Cucumber JSON report from reader
+func Decode(reader io.Reader) ([]*Feature, error) {
+	features := make([]*Feature, 0)
+	err := json.NewDecoder(reader).Decode(&features)
+	if err != nil {
+		return nil, err
+	}
+	return features, nil
+}
@@ -0,0 +1,124 @@
+package model
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Unmarshal", func() {
+	It("reads the report of cucumber-jvm", func() {
+		features, err := Unmarshal([]byte(`[{
+			"uri": "classpath:features/a.feature",
+			"id": "a",
+			"keyword": "Feature",
+			"name": "A",
+			"line": 1,
+			"description": "",
+			"elements": [{
+				"start_timestamp": "2020-09-13T12:26:40.500Z",
+				"id": "a;scenario",
+				"keyword": "Scenario",
+				"name": "Scenario",
+				"line": 3,
+				"description": "",
+				"type": "scenario",
+				"steps": [{
+					"keyword": "Given ",
+					"name": "a step",
+					"line": 4,
+					"result": {"status": "passed", "duration": 1000000},
+					"match": {"location": "Steps.a_step()"},
+					"embeddings": [{"data": "aGVsbG8=", "mime_type": "text/plain", "name": "greeting"}]
+				}]
+			}]
+		}]`))
+
+		Expect(err).To(BeNil())
+		Expect(len(features)).To(Equal(1))
+		element := features[0].Elements[0]
+		Expect(element.StartTimestamp).To(Equal("2020-09-13T12:26:40.500Z"))
+		Expect(element.Steps[0].Result.Duration).To(Equal(uint64(1000000)))
+		Expect(element.Steps[0].Match.Location).To(Equal("Steps.a_step()"))
+		Expect(element.Steps[0].Embeddings[0].Name).To(Equal("greeting"))
+	})
+
+	It("reads the hidden hook steps and the step arguments of cucumber-js", func() {
+		features, err := Unmarshal([]byte(`[{
+			"uri": "features/a.feature",
+			"id": "a",
+			"keyword": "Feature",
+			"name": "A",
+			"line": 1,
+			"description": "",
+			"elements": [{
+				"id": "a;scenario",
+				"keyword": "Scenario",
+				"name": "Scenario",
+				"line": 3,
+				"description": "",
+				"type": "scenario",
+				"steps": [
+					{"keyword": "Before", "hidden": true, "result": {"status": "passed"}},
+					{"keyword": "Given ", "name": "a table", "line": 4, "arguments": [{"rows": [{"cells": ["a", "b"]}]}], "result": {"status": "passed"}}
+				]
+			}]
+		}]`))
+
+		Expect(err).To(BeNil())
+		steps := features[0].Elements[0].Steps
+		Expect(steps[0].Hidden).To(BeTrue())
+		Expect(steps[1].Arguments[0].Rows[0].Cells).To(Equal([]string{"a", "b"}))
+	})
+
+	It("reads an empty report", func() {
+		features, err := Unmarshal([]byte("[]"))
+		Expect(err).To(BeNil())
+		Expect(features).To(BeEmpty())
+	})
+
+	It("returns an error for an invalid report", func() {
+		_, err := Unmarshal([]byte(`{"uri": "a.feature"}`))
+		Expect(err).NotTo(BeNil())
+	})
+})
+
+var _ = Describe("Decode", func() {
+	It("reads a report written by the formatter", func() {
+		reader, err := os.Open("../testdata/dialects/cucumber-jvm/dialects.json")
+		Expect(err).To(BeNil())
+		defer reader.Close()
+
+		features, err := Decode(reader)
+		Expect(err).To(BeNil())
+
+		output, err := json.Marshal(features)
+		Expect(err).To(BeNil())
+		expected, err := ioutil.ReadFile("../testdata/dialects/cucumber-jvm/dialects.json")
+		Expect(err).To(BeNil())
+		Expect(output).To(MatchJSON(expected))
+	})
+
+	It("returns an error for a truncated report", func() {
+		_, err := Decode(strings.NewReader(`[{"uri": "a.feature"`))
+		Expect(err).NotTo(BeNil())
+	})
+})
+
+var _ = Describe("Embedding", func() {
+	It("always has data when inline", func() {
+		output, err := json.Marshal(&Embedding{MimeType: "text/plain"})
+		Expect(err).To(BeNil())
+		Expect(output).To(MatchJSON(`{"data": "", "mime_type": "text/plain"}`))
+	})
+
+	It("has no data when written to a file", func() {
+		output, err := json.Marshal(&Embedding{MimeType: "image/png", Path: "attachments/image.png"})
+		Expect(err).To(BeNil())
+		Expect(output).To(MatchJSON(`{"mime_type": "image/png", "path": "attachments/image.png"}`))
+	})
+})
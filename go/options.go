@@ -0,0 +1,14 @@
+package json
+
+// Options changes how test cases are rendered
+type Options struct {
+	// UsePickleName renders the name of the Pickle instead of the name of the
+	// Scenario, so that the placeholders of Scenario Outlines are substituted.
+	// cucumber-ruby uses the name of the Scenario.
+	UsePickleName bool
+	// StepStartTimestamps renders the start time of each step and hook, next
+	// to the start time of the scenarios.
+	StepStartTimestamps bool
+	// Dialect tells which Cucumber implementation the JSON looks like
+	Dialect Dialect
+}
@@ -0,0 +1,95 @@
+package json
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cucumber/common/messages/go/v18"
+)
+
+// TestRunReporter renders the test run in a format of its own. The Formatter
+// hands every reporter the same test cases, so that a single pass over the
+// messages feeds all of them.
+type TestRunReporter interface {
+	// TestCaseFinished receives each reported test case, with its steps and
+	// attachments, as soon as it finished
+	TestCaseFinished(testCase *TestCase) error
+	// TestRunFinished receives the run-level results once every message was
+	// read: the reporter writes what it did not write yet
+	TestRunFinished(testRun *TestRun) error
+}
+
+// TestRun holds the run-level results
+type TestRun struct {
+	// TestRunStarted is the first one of the streams, or nil if there is none
+	TestRunStarted *messages.TestRunStarted
+	// TestRunFinished is the last one of the streams, or nil if there is none.
+	// It is not a success if one of the streams was not.
+	TestRunFinished *messages.TestRunFinished
+	// Attachments are the attachments which are not attached to a test case,
+	// such as those of the hooks running once per run
+	Attachments []*messages.Attachment
+	// ParseErrors are the errors of the feature files which could not be parsed
+	ParseErrors []*messages.ParseError
+}
+
+// ReporterFactory makes a TestRunReporter writing to writer, following the
+// settings of formatter
+type ReporterFactory func(formatter *Formatter, writer io.Writer) (TestRunReporter, error)
+
+var (
+	reporterFactoriesMutex sync.RWMutex
+	reporterFactories      = make(map[string]ReporterFactory)
+)
+
+func init() {
+	RegisterReporter("json", newJSONReporter)
+	RegisterReporter("junit", newJUnitReporter)
+	RegisterReporter("html", newHTMLReporter)
+	RegisterReporter("markdown", newMarkdownReporter)
+	RegisterReporter("rerun", newRerunReporter)
+}
+
+// RegisterReporter makes a format available by name to NewReporter. As it is
+// meant to be called from an init function, it panics if the name is already
+// taken or if factory is nil.
+func RegisterReporter(name string, factory ReporterFactory) {
+	reporterFactoriesMutex.Lock()
+	defer reporterFactoriesMutex.Unlock()
+
+	if factory == nil {
+		panic("json-formatter: RegisterReporter factory is nil")
+	}
+	if _, ok := reporterFactories[name]; ok {
+		panic("json-formatter: RegisterReporter called twice for " + name)
+	}
+	reporterFactories[name] = factory
+}
+
+// ReporterNames lists the registered formats, in alphabetical order
+func ReporterNames() []string {
+	reporterFactoriesMutex.RLock()
+	defer reporterFactoriesMutex.RUnlock()
+
+	names := make([]string, 0, len(reporterFactories))
+	for name := range reporterFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewReporter makes a TestRunReporter of the format registered as name
+func NewReporter(name string, formatter *Formatter, writer io.Writer) (TestRunReporter, error) {
+	reporterFactoriesMutex.RLock()
+	factory, ok := reporterFactories[name]
+	reporterFactoriesMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q (expected one of: %s)", name, strings.Join(ReporterNames(), ", "))
+	}
+	return factory(formatter, writer)
+}
@@ -0,0 +1,151 @@
+package json
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/cucumber/common/messages/go/v18"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// recordingReporter keeps what the Formatter hands it
+type recordingReporter struct {
+	testCases []*TestCase
+	testRun   *TestRun
+}
+
+func (self *recordingReporter) TestCaseFinished(testCase *TestCase) error {
+	self.testCases = append(self.testCases, testCase)
+	return nil
+}
+
+func (self *recordingReporter) TestRunFinished(testRun *TestRun) error {
+	self.testRun = testRun
+	return nil
+}
+
+var _ = Describe("Formatter with reporters", func() {
+	var (
+		formatter *Formatter
+		envelopes []*messages.Envelope
+	)
+
+	BeforeEach(func() {
+		formatter = &Formatter{}
+		envelopes = makeScenarioARun(messages.TestStepResultStatus_PASSED)
+	})
+
+	It("feeds every reporter in a single pass", func() {
+		jsonOutput := &bytes.Buffer{}
+		junitOutput := &bytes.Buffer{}
+		jsonReporter, err := NewReporter("json", formatter, jsonOutput)
+		Expect(err).To(BeNil())
+		junitReporter, err := NewReporter("junit", formatter, junitOutput)
+		Expect(err).To(BeNil())
+		formatter.AddReporter(jsonReporter)
+		formatter.AddReporter(junitReporter)
+
+		Expect(formatter.ReadMessages(strings.NewReader(makeMessagesStream(envelopes)))).To(Succeed())
+		Expect(formatter.EndReport()).To(Succeed())
+
+		expectedFormatter := &Formatter{}
+		Expect(expectedFormatter.ReadMessages(strings.NewReader(makeMessagesStream(envelopes)))).To(Succeed())
+		expectedJSON := &bytes.Buffer{}
+		Expect(expectedFormatter.WriteJSON(expectedJSON)).To(Succeed())
+		expectedJUnit := &bytes.Buffer{}
+		Expect(expectedFormatter.WriteJUnit(expectedJUnit)).To(Succeed())
+		Expect(jsonOutput.String()).To(Equal(expectedJSON.String()))
+		Expect(junitOutput.String()).To(Equal(expectedJUnit.String()))
+	})
+
+	It("hands the test cases and the run-level results to the reporters", func() {
+		reporter := &recordingReporter{}
+		formatter.AddReporter(reporter)
+		envelopes = append(envelopes,
+			&messages.Envelope{Attachment: &messages.Attachment{Body: "run", MediaType: "text/plain"}},
+			&messages.Envelope{TestRunFinished: &messages.TestRunFinished{Success: true}},
+		)
+
+		for _, envelope := range envelopes {
+			Expect(formatter.Handle(envelope)).To(Succeed())
+		}
+		Expect(formatter.EndReport()).To(Succeed())
+
+		Expect(len(reporter.testCases)).To(Equal(1))
+		Expect(reporter.testCases[0].Pickle.Name).To(Equal("Scenario A"))
+		Expect(reporter.testCases[0].Steps[0].Result.Status).To(Equal(messages.TestStepResultStatus_PASSED))
+		Expect(reporter.testRun.TestRunFinished.Success).To(BeTrue())
+		Expect(len(reporter.testRun.Attachments)).To(Equal(1))
+	})
+
+	It("does not keep the test cases for WriteJSON", func() {
+		formatter.AddReporter(&recordingReporter{})
+
+		Expect(formatter.ReadMessages(strings.NewReader(makeMessagesStream(envelopes)))).To(Succeed())
+
+		Expect(formatter.testCases).To(BeEmpty())
+		Expect(formatter.report.jsonFeatures).To(BeEmpty())
+	})
+
+	It("keeps the failure of a stream when merging several ones", func() {
+		reporter := &recordingReporter{}
+		formatter.AddReporter(reporter)
+		first := append(envelopes, &messages.Envelope{TestRunFinished: &messages.TestRunFinished{Success: false}})
+		second := append(makeSingleScenarioRun("b.feature", "Feature B", "Scenario B", messages.TestStepResultStatus_PASSED),
+			&messages.Envelope{TestRunFinished: &messages.TestRunFinished{Success: true}})
+
+		Expect(formatter.ReadMessages(strings.NewReader(makeMessagesStream(first)))).To(Succeed())
+		Expect(formatter.ReadMessages(strings.NewReader(makeMessagesStream(second)))).To(Succeed())
+		Expect(formatter.EndReport()).To(Succeed())
+
+		Expect(len(reporter.testCases)).To(Equal(2))
+		Expect(reporter.testRun.TestRunFinished.Success).To(BeFalse())
+	})
+
+	It("streams the JSON with StreamJSON", func() {
+		formatter.StreamJSON = true
+		output := &bytes.Buffer{}
+		reporter, err := NewReporter("json", formatter, output)
+		Expect(err).To(BeNil())
+		formatter.AddReporter(reporter)
+
+		Expect(formatter.ReadMessages(strings.NewReader(makeMessagesStream(envelopes)))).To(Succeed())
+		Expect(output.String()).To(ContainSubstring(`"name": "Scenario A"`))
+		Expect(formatter.EndReport()).To(Succeed())
+
+		expected := &bytes.Buffer{}
+		Expect((&Formatter{}).ProcessMessages(strings.NewReader(makeMessagesStream(envelopes)), expected)).To(Succeed())
+		Expect(output.String()).To(MatchJSON(expected.String()))
+	})
+})
+
+var _ = Describe("NewReporter", func() {
+	It("returns an error for unknown formats", func() {
+		_, err := NewReporter("cobol", &Formatter{}, &bytes.Buffer{})
+		Expect(err).To(MatchError(`unknown format "cobol" (expected one of: html, json, junit, markdown, rerun)`))
+	})
+})
+
+var _ = Describe("RegisterReporter", func() {
+	It("makes the format available by name", func() {
+		RegisterReporter("recording", func(formatter *Formatter, writer io.Writer) (TestRunReporter, error) {
+			return &recordingReporter{}, nil
+		})
+		defer func() {
+			reporterFactoriesMutex.Lock()
+			delete(reporterFactories, "recording")
+			reporterFactoriesMutex.Unlock()
+		}()
+
+		Expect(ReporterNames()).To(Equal([]string{"html", "json", "junit", "markdown", "recording", "rerun"}))
+		reporter, err := NewReporter("recording", &Formatter{}, &bytes.Buffer{})
+		Expect(err).To(BeNil())
+		Expect(reporter).To(BeAssignableToTypeOf(&recordingReporter{}))
+	})
+
+	It("panics when a name is taken", func() {
+		Expect(func() { RegisterReporter("json", newJSONReporter) }).To(Panic())
+	})
+})
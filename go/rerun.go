@@ -0,0 +1,130 @@
+package json
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/cucumber/common/messages/go/v18"
+)
+
+// DefaultRerunStatuses are the statuses of the scenarios listed by the rerun
+// format when Formatter.RerunStatuses is empty
+var DefaultRerunStatuses = []messages.TestStepResultStatus{
+	messages.TestStepResultStatus_FAILED,
+	messages.TestStepResultStatus_AMBIGUOUS,
+	messages.TestStepResultStatus_UNDEFINED,
+	messages.TestStepResultStatus_PENDING,
+}
+
+// ParseRerunStatuses returns the statuses listed in s, separated by commas
+func ParseRerunStatuses(s string) ([]messages.TestStepResultStatus, error) {
+	statuses := make([]messages.TestStepResultStatus, 0)
+	for _, name := range strings.Split(s, ",") {
+		status := messages.TestStepResultStatus(strings.ToUpper(strings.TrimSpace(name)))
+		switch status {
+		case messages.TestStepResultStatus_PASSED,
+			messages.TestStepResultStatus_FAILED,
+			messages.TestStepResultStatus_AMBIGUOUS,
+			messages.TestStepResultStatus_UNDEFINED,
+			messages.TestStepResultStatus_PENDING,
+			messages.TestStepResultStatus_SKIPPED:
+			statuses = append(statuses, status)
+		default:
+			return nil, fmt.Errorf("unknown status %q (expected some of: passed, failed, ambiguous, undefined, pending, skipped)", name)
+		}
+	}
+	return statuses, nil
+}
+
+// rerunReporter lists the locations of the scenarios to run again, as the
+// rerun formatter of cucumber-ruby does: features/a.feature:12:40. The
+// scenarios of a Scenario Outline are located by their example row, and the
+// feature files which can not be parsed are failed at the line of the error.
+// The parse errors without a source are left out.
+type rerunReporter struct {
+	writer   io.Writer
+	statuses map[messages.TestStepResultStatus]bool
+
+	uris []string
+	// rerunByLine tells, for each URI, whether the last attempt of the
+	// scenario at each line is to be run again. Line 0 is the whole file.
+	rerunByLine map[string]map[int64]bool
+}
+
+func newRerunReporter(formatter *Formatter, writer io.Writer) (TestRunReporter, error) {
+	statuses := formatter.RerunStatuses
+	if len(statuses) == 0 {
+		statuses = DefaultRerunStatuses
+	}
+
+	reporter := &rerunReporter{
+		writer:      writer,
+		statuses:    make(map[messages.TestStepResultStatus]bool),
+		uris:        make([]string, 0),
+		rerunByLine: make(map[string]map[int64]bool),
+	}
+	for _, status := range statuses {
+		reporter.statuses[status] = true
+	}
+	return reporter, nil
+}
+
+func (self *rerunReporter) TestCaseFinished(testCase *TestCase) error {
+	status := testCase.Status()
+	// cucumber-ruby has no "unknown" status, as in makeStatus
+	if status == messages.TestStepResultStatus_UNKNOWN {
+		status = messages.TestStepResultStatus_UNDEFINED
+	}
+
+	self.setRerun(testCase.Pickle.Uri, testCase.Line(), self.statuses[status])
+	return nil
+}
+
+func (self *rerunReporter) TestRunFinished(testRun *TestRun) error {
+	for _, parseError := range testRun.ParseErrors {
+		uri, line := parseErrorLocation(parseError)
+		// There is no feature file to run again without a source
+		if uri == "" {
+			continue
+		}
+		self.setRerun(uri, line, self.statuses[messages.TestStepResultStatus_FAILED])
+	}
+
+	for _, uri := range self.uris {
+		lines := make([]int64, 0)
+		for line, rerun := range self.rerunByLine[uri] {
+			if rerun {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		sort.Slice(lines, func(i, j int) bool { return lines[i] < lines[j] })
+
+		location := uri
+		// Line 0 being the whole file, the other lines are run anyway
+		if lines[0] != 0 {
+			for _, line := range lines {
+				location += fmt.Sprintf(":%d", line)
+			}
+		}
+		_, err := fmt.Fprintln(self.writer, location)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *rerunReporter) setRerun(uri string, line int64, rerun bool) {
+	rerunByLine, ok := self.rerunByLine[uri]
+	if !ok {
+		rerunByLine = make(map[int64]bool)
+		self.rerunByLine[uri] = rerunByLine
+		self.uris = append(self.uris, uri)
+	}
+	rerunByLine[line] = rerun
+}
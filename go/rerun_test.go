@@ -0,0 +1,114 @@
+package json
+
+import (
+	"bytes"
+
+	"github.com/cucumber/common/messages/go/v18"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("rerunReporter", func() {
+	var (
+		formatter *Formatter
+		output    *bytes.Buffer
+	)
+
+	makeRerunTestCase := func(uri string, line int64, status messages.TestStepResultStatus) *TestCase {
+		return &TestCase{
+			Scenario: &messages.Scenario{Location: &messages.Location{Line: line}},
+			Pickle:   &messages.Pickle{Uri: uri, AstNodeIds: []string{"scenario-id"}},
+			Steps:    []*TestStep{{Result: &messages.TestStepResult{Status: status}}},
+		}
+	}
+
+	report := func(testCases ...*TestCase) string {
+		reporter, err := NewReporter("rerun", formatter, output)
+		Expect(err).To(BeNil())
+		for _, testCase := range testCases {
+			Expect(reporter.TestCaseFinished(testCase)).To(Succeed())
+		}
+		Expect(reporter.TestRunFinished(&TestRun{})).To(Succeed())
+		return output.String()
+	}
+
+	BeforeEach(func() {
+		formatter = &Formatter{}
+		output = &bytes.Buffer{}
+	})
+
+	It("groups the locations of the scenarios to run again by URI", func() {
+		Expect(report(
+			makeRerunTestCase("features/a.feature", 40, messages.TestStepResultStatus_FAILED),
+			makeRerunTestCase("features/b.feature", 3, messages.TestStepResultStatus_PENDING),
+			makeRerunTestCase("features/a.feature", 12, messages.TestStepResultStatus_UNDEFINED),
+			makeRerunTestCase("features/a.feature", 20, messages.TestStepResultStatus_PASSED),
+			makeRerunTestCase("features/c.feature", 5, messages.TestStepResultStatus_SKIPPED),
+		)).To(Equal("features/a.feature:12:40\nfeatures/b.feature:3\n"))
+	})
+
+	It("locates the scenarios of a Scenario Outline by their example row", func() {
+		testCase := makeRerunTestCase("features/a.feature", 3, messages.TestStepResultStatus_FAILED)
+		testCase.Scenario.Examples = []*messages.Examples{{
+			TableBody: []*messages.TableRow{{Id: "row-id", Location: &messages.Location{Line: 9}}},
+		}}
+		testCase.Pickle.AstNodeIds = []string{"scenario-id", "row-id"}
+
+		Expect(report(testCase)).To(Equal("features/a.feature:9\n"))
+	})
+
+	It("follows the last attempt of the retried scenarios", func() {
+		Expect(report(
+			makeRerunTestCase("features/a.feature", 3, messages.TestStepResultStatus_FAILED),
+			makeRerunTestCase("features/a.feature", 3, messages.TestStepResultStatus_PASSED),
+		)).To(BeEmpty())
+	})
+
+	It("lists the scenarios having one of RerunStatuses", func() {
+		formatter.RerunStatuses = []messages.TestStepResultStatus{messages.TestStepResultStatus_FAILED}
+
+		Expect(report(
+			makeRerunTestCase("features/a.feature", 3, messages.TestStepResultStatus_FAILED),
+			makeRerunTestCase("features/a.feature", 7, messages.TestStepResultStatus_UNDEFINED),
+		)).To(Equal("features/a.feature:3\n"))
+	})
+
+	It("lists the feature files which can not be parsed", func() {
+		reporter, err := NewReporter("rerun", formatter, output)
+		Expect(err).To(BeNil())
+		Expect(reporter.TestCaseFinished(makeRerunTestCase("features/a.feature", 3, messages.TestStepResultStatus_FAILED))).To(Succeed())
+
+		Expect(reporter.TestRunFinished(&TestRun{
+			ParseErrors: []*messages.ParseError{
+				{Source: &messages.SourceReference{Uri: "features/b.feature", Location: &messages.Location{Line: 5}}},
+				{Source: &messages.SourceReference{Uri: "features/c.feature"}},
+			},
+		})).To(Succeed())
+
+		Expect(output.String()).To(Equal("features/a.feature:3\nfeatures/b.feature:5\nfeatures/c.feature\n"))
+	})
+
+	It("leaves out the parse errors without a source", func() {
+		reporter, err := NewReporter("rerun", formatter, output)
+		Expect(err).To(BeNil())
+		Expect(reporter.TestRunFinished(&TestRun{
+			ParseErrors: []*messages.ParseError{{Message: "no source"}},
+		})).To(Succeed())
+
+		Expect(output.String()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("ParseRerunStatuses", func() {
+	It("returns the statuses separated by commas", func() {
+		Expect(ParseRerunStatuses("failed, Undefined")).To(Equal([]messages.TestStepResultStatus{
+			messages.TestStepResultStatus_FAILED,
+			messages.TestStepResultStatus_UNDEFINED,
+		}))
+	})
+
+	It("returns an error for unknown statuses", func() {
+		_, err := ParseRerunStatuses("failed,broken")
+		Expect(err).To(MatchError(`unknown status "broken" (expected some of: passed, failed, ambiguous, undefined, pending, skipped)`))
+	})
+})
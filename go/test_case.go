@@ -1,7 +1,6 @@
 package json
 
 import (
-	"errors"
 	"fmt"
 	"strings"
 
@@ -9,12 +8,19 @@ import (
 )
 
 type TestCase struct {
-	FeatureName string
-	Scenario    *messages.Scenario
-	Pickle      *messages.Pickle
-	TestCase    *messages.TestCase
-	Steps       []*TestStep
-	Tags        []*messages.Tag
+	FeatureName    string
+	Rule           *messages.Rule
+	Scenario       *messages.Scenario
+	Pickle         *messages.Pickle
+	TestCase       *messages.TestCase
+	Steps          []*TestStep
+	Tags           []*messages.Tag
+	Attempt        int64
+	StartTimestamp *messages.Timestamp
+	Attachments    []*messages.Attachment
+	// WillBeRetried tells whether the test case is attempted again, this
+	// attempt not being the final one
+	WillBeRetried bool
 }
 
 type SortedSteps struct {
@@ -22,70 +28,101 @@ type SortedSteps struct {
 	Background []*TestStep
 	Steps      []*TestStep
 	AfterHook  []*TestStep
+	// BeforeStepHooks and AfterStepHooks hold the hooks wrapping each step of
+	// Background and Steps
+	BeforeStepHooks map[*TestStep][]*TestStep
+	AfterStepHooks  map[*TestStep][]*TestStep
 }
 
 func ProcessTestCaseStarted(testCaseStarted *messages.TestCaseStarted, lookup *MessageLookup) (error, *TestCase) {
 	testCase := lookup.LookupTestCase(testCaseStarted.TestCaseId)
 	if testCase == nil {
-		return errors.New("No testCase for " + testCaseStarted.TestCaseId), nil
+		return missingReference("TestCase", testCaseStarted.TestCaseId), nil
 	}
 
 	pickle := lookup.LookupPickle(testCase.PickleId)
 	if pickle == nil || len(pickle.AstNodeIds) == 0 {
-		return errors.New("No pickle for " + testCase.PickleId), nil
+		return missingReference("Pickle", testCase.PickleId), nil
 	}
 
 	tags := make([]*messages.Tag, len(pickle.Tags))
 	for index, tag := range pickle.Tags {
 		sourceTag := lookup.LookupTag(tag.AstNodeId)
 		if sourceTag == nil {
-			return errors.New("No sourceTag for " + tag.AstNodeId), nil
+			return missingReference("Tag", tag.AstNodeId), nil
 		}
 		tags[index] = sourceTag
 	}
 
 	scenario := lookup.LookupScenario(pickle.AstNodeIds[0])
 	if scenario == nil {
-		return errors.New(fmt.Sprintf("No scenario for %s", strings.Join(pickle.AstNodeIds, ", "))), nil
+		return missingReference("Scenario", pickle.AstNodeIds[0]), nil
 	}
 
 	feature := lookup.LookupGherkinDocument(pickle.Uri)
 	if feature == nil {
-		return errors.New("No feature for " + pickle.Uri), nil
+		return missingReference("GherkinDocument", pickle.Uri), nil
+	}
+	if feature.Feature == nil {
+		return missingReference("Feature", pickle.Uri), nil
 	}
 	featureName := feature.Feature.Name
 
 	return nil, &TestCase{
-		FeatureName: featureName,
-		Scenario:    scenario,
-		Pickle:      pickle,
-		TestCase:    testCase,
-		Steps:       make([]*TestStep, 0),
-		Tags:        tags,
+		FeatureName:    featureName,
+		Rule:           lookup.LookupRuleByScenarioID(scenario.Id),
+		Scenario:       scenario,
+		Pickle:         pickle,
+		TestCase:       testCase,
+		Steps:          make([]*TestStep, 0),
+		Tags:           tags,
+		Attempt:        testCaseStarted.Attempt,
+		StartTimestamp: testCaseStarted.Timestamp,
 	}
 }
 
-func TestCaseToJSON(testCase *TestCase) []*jsonFeatureElement {
+func TestCaseToJSON(testCase *TestCase, options Options) []*jsonFeatureElement {
+	if options.Dialect.profile().hooksAsSteps {
+		return []*jsonFeatureElement{hooksAsStepsToJSON(testCase, options)}
+	}
+
 	elements := make([]*jsonFeatureElement, 0)
 	sortedSteps := testCase.SortedSteps()
 
-	if len(sortedSteps.Background) > 0 {
-		elements = append(elements, backgroundStepsToJSON(sortedSteps.Background))
+	for _, backgroundSteps := range groupByBackground(sortedSteps.Background) {
+		elements = append(elements, backgroundStepsToJSON(backgroundSteps, sortedSteps, options))
 	}
-	elements = append(elements, scenarioStepsToJSON(testCase, sortedSteps.Steps))
+	elements = append(elements, scenarioStepsToJSON(testCase, sortedSteps.Steps, sortedSteps, options))
 
 	if len(sortedSteps.BeforeHook) > 0 {
-		elements[0].Before = makeJSONSteps(sortedSteps.BeforeHook)
+		elements[0].Before = makeJSONSteps(sortedSteps.BeforeHook, sortedSteps, options)
 	}
 
 	if len(sortedSteps.AfterHook) > 0 {
-		elements[len(elements)-1].After = makeJSONSteps(sortedSteps.AfterHook)
+		elements[len(elements)-1].After = makeJSONSteps(sortedSteps.AfterHook, sortedSteps, options)
 	}
 
 	return elements
 }
 
-func backgroundStepsToJSON(steps []*TestStep) *jsonFeatureElement {
+// hooksAsStepsToJSON renders the test case as cucumber-js does: a single element
+// with all the steps in the order they ran, the hooks being hidden steps
+func hooksAsStepsToJSON(testCase *TestCase, options Options) *jsonFeatureElement {
+	element := scenarioStepsToJSON(testCase, testCase.Steps, &SortedSteps{}, options)
+
+	keyword := "Before"
+	for index, step := range testCase.Steps {
+		if step.Hook == nil {
+			keyword = "After"
+			continue
+		}
+		element.Steps[index].Keyword = keyword
+		element.Steps[index].Hidden = true
+	}
+	return element
+}
+
+func backgroundStepsToJSON(steps []*TestStep, sortedSteps *SortedSteps, options Options) *jsonFeatureElement {
 	background := steps[0].Background
 
 	return &jsonFeatureElement{
@@ -94,21 +131,40 @@ func backgroundStepsToJSON(steps []*TestStep) *jsonFeatureElement {
 		Description: background.Description,
 		Line:        uint32(background.Location.Line),
 		Type:        "background",
-		Steps:       makeJSONSteps(steps),
+		Steps:       makeJSONSteps(steps, sortedSteps, options),
+	}
+}
+
+// groupByBackground splits the background steps of a test case between the
+// Background of the feature and the Background of the Rule
+func groupByBackground(steps []*TestStep) [][]*TestStep {
+	groups := make([][]*TestStep, 0)
+	for _, step := range steps {
+		last := len(groups) - 1
+		if last >= 0 && groups[last][0].Background == step.Background {
+			groups[last] = append(groups[last], step)
+		} else {
+			groups = append(groups, []*TestStep{step})
+		}
 	}
+	return groups
 }
 
-func scenarioStepsToJSON(testCase *TestCase, steps []*TestStep) *jsonFeatureElement {
-	line := testCase.Scenario.Location.Line
+func scenarioStepsToJSON(testCase *TestCase, steps []*TestStep, sortedSteps *SortedSteps, options Options) *jsonFeatureElement {
+	profile := options.Dialect.profile()
+	line := testCase.Line()
+	// The Rule is left out of the ID, as create_id_from_scenario_source of
+	// cucumber-ruby does
 	id := fmt.Sprintf("%s;%s", makeID(testCase.FeatureName), makeID(testCase.Scenario.Name))
-	if len(testCase.Pickle.AstNodeIds) > 1 {
+	if profile.pickleIDs {
+		id = fmt.Sprintf("%s;%s", makeID(testCase.FeatureName), makeID(testCase.Pickle.Name))
+	} else if len(testCase.Pickle.AstNodeIds) > 1 {
 		exampleName := ""
 		exampleIndex := 0
 
 		for _, example := range testCase.Scenario.Examples {
 			for index, row := range example.TableBody {
 				if row.Id == testCase.Pickle.AstNodeIds[1] {
-					line = row.Location.Line
 					exampleName = example.Name
 					// +2 as the index is a one-based index and the table header is taken into account
 					exampleIndex = index + 2
@@ -124,22 +180,42 @@ func scenarioStepsToJSON(testCase *TestCase, steps []*TestStep) *jsonFeatureElem
 		)
 	}
 
+	name := testCase.Scenario.Name
+	if options.UsePickleName {
+		name = testCase.Pickle.Name
+	}
+
+	startTimestamp := ""
+	if profile.startTimestamps {
+		startTimestamp = makeTimestamp(testCase.StartTimestamp)
+	}
+
 	return &jsonFeatureElement{
-		ID:          id,
-		Keyword:     testCase.Scenario.Keyword,
-		Type:        "scenario",
-		Name:        testCase.Scenario.Name,
-		Description: testCase.Scenario.Description,
-		Line:        uint32(line),
-		Steps:       makeJSONSteps(steps),
-		Tags:        makeJSONTags(testCase.Tags),
+		ID:             id,
+		Keyword:        testCase.Scenario.Keyword,
+		Type:           "scenario",
+		Name:           name,
+		Description:    testCase.Scenario.Description,
+		Line:           uint32(line),
+		StartTimestamp: startTimestamp,
+		Steps:          makeJSONSteps(steps, sortedSteps, options),
+		Tags:           makeJSONTags(testCase.Tags),
+		Embeddings:     makeEmbeddings(testCase.Attachments),
+		Output:         makeOutput(testCase.Attachments),
 	}
 }
 
-func makeJSONSteps(steps []*TestStep) []*jsonStep {
+// makeJSONSteps renders the steps, each one with the hooks wrapping it
+func makeJSONSteps(steps []*TestStep, sortedSteps *SortedSteps, options Options) []*jsonStep {
 	jsonSteps := make([]*jsonStep, len(steps))
 	for index, step := range steps {
-		jsonSteps[index] = TestStepToJSON(step)
+		jsonSteps[index] = TestStepToJSON(step, options)
+		if hooks := sortedSteps.BeforeStepHooks[step]; len(hooks) > 0 {
+			jsonSteps[index].Before = makeJSONSteps(hooks, sortedSteps, options)
+		}
+		if hooks := sortedSteps.AfterStepHooks[step]; len(hooks) > 0 {
+			jsonSteps[index].After = makeJSONSteps(hooks, sortedSteps, options)
+		}
 	}
 	return jsonSteps
 }
@@ -159,11 +235,77 @@ func (self *TestCase) appendStep(step *TestStep) {
 	self.Steps = append(self.Steps, step)
 }
 
+// Line is the line of the scenario, or the one of its example row for the
+// pickles of a Scenario Outline
+func (self *TestCase) Line() int64 {
+	if len(self.Pickle.AstNodeIds) > 1 {
+		for _, example := range self.Scenario.Examples {
+			for _, row := range example.TableBody {
+				if row.Id == self.Pickle.AstNodeIds[1] {
+					return row.Location.Line
+				}
+			}
+		}
+	}
+	return self.Scenario.Location.Line
+}
+
+// Status is the status of the step which matters the most: failed, ambiguous,
+// undefined, unknown, pending, skipped, then passed. A test case without steps
+// passed.
+func (self *TestCase) Status() messages.TestStepResultStatus {
+	step := firstStepWithStatus(
+		self,
+		messages.TestStepResultStatus_FAILED,
+		messages.TestStepResultStatus_AMBIGUOUS,
+		messages.TestStepResultStatus_UNDEFINED,
+		messages.TestStepResultStatus_UNKNOWN,
+		messages.TestStepResultStatus_PENDING,
+		messages.TestStepResultStatus_SKIPPED,
+	)
+	if step == nil {
+		return messages.TestStepResultStatus_PASSED
+	}
+	return step.Result.Status
+}
+
+// SortedSteps splits the steps of the test case between the Before hooks, the
+// Background steps, the Scenario steps and the After hooks.
+//
+// The hooks wrapping the steps (BeforeStep and AfterStep hooks) are told apart
+// from the scenario hooks as they run more than once, or between two steps. A
+// BeforeStep hook first runs before the first step, and an AfterStep hook after
+// it. As a hook running once between two steps can not be told apart, it is an
+// AfterStep hook of the previous step.
 func (self *TestCase) SortedSteps() *SortedSteps {
-	sorted := &SortedSteps{}
+	sorted := &SortedSteps{
+		BeforeStepHooks: make(map[*TestStep][]*TestStep),
+		AfterStepHooks:  make(map[*TestStep][]*TestStep),
+	}
+	isBeforeStepHook := self.stepHooks()
 	current := &sorted.BeforeHook
+	var previousStep *TestStep
+	pendingHooks := make([]*TestStep, 0)
 
 	for _, step := range self.Steps {
+		if step.Hook != nil {
+			isBeforeStep, isStepHook := isBeforeStepHook[step.Hook.Id]
+			if isStepHook && isBeforeStep {
+				pendingHooks = append(pendingHooks, step)
+				continue
+			}
+			if isStepHook && previousStep != nil {
+				sorted.AfterStepHooks[previousStep] = append(sorted.AfterStepHooks[previousStep], step)
+				continue
+			}
+		} else {
+			if len(pendingHooks) > 0 {
+				sorted.BeforeStepHooks[step] = pendingHooks
+				pendingHooks = make([]*TestStep, 0)
+			}
+			previousStep = step
+		}
+
 		if current == &sorted.BeforeHook && step.Hook == nil {
 			current = &sorted.Background
 		}
@@ -176,10 +318,58 @@ func (self *TestCase) SortedSteps() *SortedSteps {
 
 		*current = append(*current, step)
 	}
+	// BeforeStep hooks which did not run before any step (e.g. the run was interrupted)
+	sorted.AfterHook = append(sorted.AfterHook, pendingHooks...)
 
 	return sorted
 }
 
+// stepHooks returns the IDs of the hooks wrapping the steps, telling whether they
+// are BeforeStep hooks
+func (self *TestCase) stepHooks() map[string]bool {
+	firstStepIndex, lastStepIndex := -1, -1
+	for index, step := range self.Steps {
+		if step.Hook == nil {
+			if firstStepIndex < 0 {
+				firstStepIndex = index
+			}
+			lastStepIndex = index
+		}
+	}
+
+	hookCounts := make(map[string]int)
+	isBetweenSteps := make(map[string]bool)
+	isBeforeStepHook := make(map[string]bool)
+	for index, step := range self.Steps {
+		if step.Hook == nil {
+			continue
+		}
+		hookCounts[step.Hook.Id]++
+		if hookCounts[step.Hook.Id] == 1 {
+			isBeforeStepHook[step.Hook.Id] = index < firstStepIndex
+		}
+		if index > firstStepIndex && index < lastStepIndex {
+			isBetweenSteps[step.Hook.Id] = true
+		}
+	}
+
+	for hookId, count := range hookCounts {
+		if count == 1 && !isBetweenSteps[hookId] {
+			delete(isBeforeStepHook, hookId)
+		}
+	}
+	return isBeforeStepHook
+}
+
+// makeTimestamp renders a timestamp in ISO-8601, in UTC with milliseconds as
+// cucumber-jvm does
+func makeTimestamp(timestamp *messages.Timestamp) string {
+	if timestamp == nil {
+		return ""
+	}
+	return messages.TimestampToGoTime(*timestamp).UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
 func makeID(s string) string {
 	return strings.ToLower(strings.Replace(s, " ", "-", -1))
 }
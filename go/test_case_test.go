@@ -44,6 +44,63 @@ var _ = Describe("TestCase.appendStep", func() {
 	})
 })
 
+var _ = Describe("TestCase.Line", func() {
+	var testCase *TestCase
+
+	BeforeEach(func() {
+		testCase = &TestCase{
+			Scenario: &messages.Scenario{
+				Location: &messages.Location{Line: 3},
+				Examples: []*messages.Examples{
+					{
+						TableBody: []*messages.TableRow{
+							{Id: "first-row", Location: &messages.Location{Line: 8}},
+							{Id: "second-row", Location: &messages.Location{Line: 9}},
+						},
+					},
+				},
+			},
+			Pickle: &messages.Pickle{AstNodeIds: []string{"scenario-id"}},
+		}
+	})
+
+	It("is the line of the scenario", func() {
+		Expect(testCase.Line()).To(Equal(int64(3)))
+	})
+
+	It("is the line of the example row of the Scenario Outlines", func() {
+		testCase.Pickle.AstNodeIds = []string{"scenario-id", "second-row"}
+		Expect(testCase.Line()).To(Equal(int64(9)))
+	})
+})
+
+var _ = Describe("TestCase.Status", func() {
+	makeTestCaseWithStatuses := func(statuses ...messages.TestStepResultStatus) *TestCase {
+		testCase := &TestCase{Steps: make([]*TestStep, 0)}
+		for _, status := range statuses {
+			testCase.appendStep(&TestStep{Result: &messages.TestStepResult{Status: status}})
+		}
+		return testCase
+	}
+
+	It("is the status of the step which matters the most", func() {
+		Expect(makeTestCaseWithStatuses(
+			messages.TestStepResultStatus_PASSED,
+			messages.TestStepResultStatus_FAILED,
+			messages.TestStepResultStatus_SKIPPED,
+		).Status()).To(Equal(messages.TestStepResultStatus_FAILED))
+		Expect(makeTestCaseWithStatuses(
+			messages.TestStepResultStatus_PASSED,
+			messages.TestStepResultStatus_PENDING,
+			messages.TestStepResultStatus_SKIPPED,
+		).Status()).To(Equal(messages.TestStepResultStatus_PENDING))
+	})
+
+	It("is passed without steps", func() {
+		Expect(makeTestCaseWithStatuses().Status()).To(Equal(messages.TestStepResultStatus_PASSED))
+	})
+})
+
 var _ = Describe("TestCase.SortedSteps", func() {
 	var (
 		firstBeforeHookStep  *TestStep
@@ -184,6 +241,97 @@ var _ = Describe("TestCase.SortedSteps", func() {
 		Expect(sorted.AfterHook[0]).To(Equal(firstAfterHookStep))
 		Expect(sorted.AfterHook[1]).To(Equal(secondAfterHookStep))
 	})
+
+	Context("when there are BeforeStep and AfterStep hooks", func() {
+		makeStepHookStep := func(hookId string) *TestStep {
+			return &TestStep{
+				Hook: &messages.Hook{
+					Id: hookId,
+				},
+			}
+		}
+
+		It("adds the hooks running before each step in SortedSteps.BeforeStepHooks", func() {
+			firstBeforeStepHookStep := makeStepHookStep("before-step-hook")
+			secondBeforeStepHookStep := makeStepHookStep("before-step-hook")
+			testCase := &TestCase{
+				Steps: []*TestStep{
+					firstBeforeHookStep,
+					firstBeforeStepHookStep,
+					firstPickleStep,
+					secondBeforeStepHookStep,
+					secondPickleStep,
+					firstAfterHookStep,
+				},
+			}
+			sorted := testCase.SortedSteps()
+
+			Expect(sorted.BeforeHook).To(Equal([]*TestStep{firstBeforeHookStep}))
+			Expect(sorted.Steps).To(Equal([]*TestStep{firstPickleStep, secondPickleStep}))
+			Expect(sorted.AfterHook).To(Equal([]*TestStep{firstAfterHookStep}))
+			Expect(sorted.BeforeStepHooks[firstPickleStep]).To(Equal([]*TestStep{firstBeforeStepHookStep}))
+			Expect(sorted.BeforeStepHooks[secondPickleStep]).To(Equal([]*TestStep{secondBeforeStepHookStep}))
+			Expect(sorted.AfterStepHooks).To(BeEmpty())
+		})
+
+		It("adds the hooks running after each step in SortedSteps.AfterStepHooks", func() {
+			firstAfterStepHookStep := makeStepHookStep("after-step-hook")
+			secondAfterStepHookStep := makeStepHookStep("after-step-hook")
+			testCase := &TestCase{
+				Steps: []*TestStep{
+					firstBeforeHookStep,
+					firstPickleStep,
+					firstAfterStepHookStep,
+					secondPickleStep,
+					secondAfterStepHookStep,
+					firstAfterHookStep,
+				},
+			}
+			sorted := testCase.SortedSteps()
+
+			Expect(sorted.BeforeHook).To(Equal([]*TestStep{firstBeforeHookStep}))
+			Expect(sorted.Steps).To(Equal([]*TestStep{firstPickleStep, secondPickleStep}))
+			Expect(sorted.AfterHook).To(Equal([]*TestStep{firstAfterHookStep}))
+			Expect(sorted.AfterStepHooks[firstPickleStep]).To(Equal([]*TestStep{firstAfterStepHookStep}))
+			Expect(sorted.AfterStepHooks[secondPickleStep]).To(Equal([]*TestStep{secondAfterStepHookStep}))
+			Expect(sorted.BeforeStepHooks).To(BeEmpty())
+		})
+
+		It("wraps the background and scenario steps with both kinds of hooks", func() {
+			steps := []*TestStep{firstBeforeHookStep}
+			for _, step := range []*TestStep{firstBackgroundStep, firstPickleStep, secondPickleStep} {
+				steps = append(steps, makeStepHookStep("before-step-hook"), step, makeStepHookStep("after-step-hook"))
+			}
+			steps = append(steps, firstAfterHookStep, secondAfterHookStep)
+			testCase := &TestCase{Steps: steps}
+			sorted := testCase.SortedSteps()
+
+			Expect(sorted.BeforeHook).To(Equal([]*TestStep{firstBeforeHookStep}))
+			Expect(sorted.Background).To(Equal([]*TestStep{firstBackgroundStep}))
+			Expect(sorted.Steps).To(Equal([]*TestStep{firstPickleStep, secondPickleStep}))
+			Expect(sorted.AfterHook).To(Equal([]*TestStep{firstAfterHookStep, secondAfterHookStep}))
+			for index, step := range []*TestStep{firstBackgroundStep, firstPickleStep, secondPickleStep} {
+				Expect(sorted.BeforeStepHooks[step]).To(Equal([]*TestStep{steps[1+index*3]}))
+				Expect(sorted.AfterStepHooks[step]).To(Equal([]*TestStep{steps[3+index*3]}))
+			}
+		})
+
+		It("adds a hook running once between two steps to the AfterStep hooks of the previous step", func() {
+			hookStep := makeStepHookStep("some-hook")
+			testCase := &TestCase{
+				Steps: []*TestStep{
+					firstPickleStep,
+					hookStep,
+					secondPickleStep,
+				},
+			}
+			sorted := testCase.SortedSteps()
+
+			Expect(sorted.Steps).To(Equal([]*TestStep{firstPickleStep, secondPickleStep}))
+			Expect(sorted.AfterHook).To(BeEmpty())
+			Expect(sorted.AfterStepHooks[firstPickleStep]).To(Equal([]*TestStep{hookStep}))
+		})
+	})
 })
 
 var _ = Describe("ProcessTestCaseStarted", func() {
@@ -237,6 +385,8 @@ var _ = Describe("ProcessTestCaseStarted", func() {
 
 		_, testCase = ProcessTestCaseStarted(&messages.TestCaseStarted{
 			TestCaseId: testCaseMsg.Id,
+			Attempt:    2,
+			Timestamp:  &messages.Timestamp{Seconds: 1600000000},
 		}, lookup)
 	})
 
@@ -256,6 +406,33 @@ var _ = Describe("ProcessTestCaseStarted", func() {
 		Expect(testCase.Scenario.Id).To(Equal("scenario-id"))
 	})
 
+	It("has no Rule when the scenario is not in a Rule", func() {
+		Expect(testCase.Rule).To(BeNil())
+	})
+
+	Context("when the scenario is in a Rule", func() {
+		It("has a reference to the Rule", func() {
+			rule := &messages.Rule{
+				Name:     "A rule",
+				Children: []*messages.RuleChild{{Scenario: scenario}},
+			}
+			lookup.processRule(rule)
+
+			_, testCase = ProcessTestCaseStarted(&messages.TestCaseStarted{
+				TestCaseId: "test-case-id",
+			}, lookup)
+			Expect(testCase.Rule).To(Equal(rule))
+		})
+	})
+
+	It("has the attempt", func() {
+		Expect(testCase.Attempt).To(Equal(int64(2)))
+	})
+
+	It("has the start timestamp", func() {
+		Expect(testCase.StartTimestamp).To(Equal(&messages.Timestamp{Seconds: 1600000000}))
+	})
+
 	It("has the tags", func() {
 		Expect(testCase.Tags[0].Id).To(Equal("tag-id"))
 		Expect(testCase.Tags[0].Name).To(Equal("@scenario-tag"))
@@ -345,6 +522,17 @@ var _ = Describe("ProcessTestCaseStarted", func() {
 			Expect(testCase).To(BeNil())
 		})
 
+		It("returns a MissingReferenceError if the TestCase has not been defined", func() {
+			err, _ := ProcessTestCaseStarted(&messages.TestCaseStarted{
+				TestCaseId: "unknown-test-case-id",
+			}, lookup)
+
+			Expect(err).To(Equal(&MissingReferenceError{
+				Kind: "TestCase",
+				ID:   "unknown-test-case-id",
+			}))
+		})
+
 		It("returns nil if the Pickle is Unknown", func() {
 			_, testCase := ProcessTestCaseStarted(&messages.TestCaseStarted{
 				TestCaseId: testCaseReferencingUnknownPickle.Id,
@@ -448,7 +636,7 @@ var _ = Describe("TestCaseToJSON", func() {
 				Status: messages.TestStepResultStatus_FAILED,
 			},
 		})
-		jsonTestCase = TestCaseToJSON(testCase)
+		jsonTestCase = TestCaseToJSON(testCase, Options{})
 	})
 
 	It("computes the ID from the FeatureName and Scenario name", func() {
@@ -459,15 +647,23 @@ var _ = Describe("TestCaseToJSON", func() {
 		Expect(jsonTestCase[0].Keyword).To(Equal("Eksempel"))
 	})
 
-	// It does not sadly: we use Cucumber-ruby for producing the
-	// acceptance tests, which does not support that (but Cucumber-JVM,
-	// Cucumber-JS do ...)
-	XIt("should have the Pickle name", func() {})
-
+	// We use Cucumber-ruby for producing the acceptance tests, which
+	// uses the Scenario name (but Cucumber-JVM, Cucumber-JS use the
+	// Pickle name ...)
 	It("has the Scenario name", func() {
 		Expect(jsonTestCase[0].Name).To(Equal("A scenario (<exampleId>)"))
 	})
 
+	It("has the Pickle name when Options.UsePickleName is set", func() {
+		jsonTestCase = TestCaseToJSON(testCase, Options{UsePickleName: true})
+		Expect(jsonTestCase[0].Name).To(Equal("A scenario (2)"))
+	})
+
+	It("keeps the Scenario name in the ID when Options.UsePickleName is set", func() {
+		jsonTestCase = TestCaseToJSON(testCase, Options{UsePickleName: true})
+		Expect(jsonTestCase[0].ID).To(Equal("my-feature;a-scenario-(<exampleid>)"))
+	})
+
 	It("has the Scenario description", func() {
 		Expect(jsonTestCase[0].Description).To(Equal("This is a scenario"))
 	})
@@ -481,6 +677,32 @@ var _ = Describe("TestCaseToJSON", func() {
 		Expect(jsonTestCase[0].Steps[0].Name).To(Equal("a passed step"))
 	})
 
+	It("has no start timestamp when the test case has no start time", func() {
+		Expect(jsonTestCase[0].StartTimestamp).To(BeEmpty())
+	})
+
+	Context("when the test case has a start time", func() {
+		BeforeEach(func() {
+			testCase.StartTimestamp = &messages.Timestamp{Seconds: 1600000000, Nanos: 123456789}
+			testCase.Steps[0].StartTimestamp = &messages.Timestamp{Seconds: 1600000001}
+		})
+
+		It("has the start timestamp in ISO-8601", func() {
+			jsonTestCase = TestCaseToJSON(testCase, Options{})
+			Expect(jsonTestCase[0].StartTimestamp).To(Equal("2020-09-13T12:26:40.123Z"))
+		})
+
+		It("has no start timestamp on the steps", func() {
+			jsonTestCase = TestCaseToJSON(testCase, Options{})
+			Expect(jsonTestCase[0].Steps[0].StartTimestamp).To(BeEmpty())
+		})
+
+		It("has the start timestamp of the steps when Options.StepStartTimestamps is set", func() {
+			jsonTestCase = TestCaseToJSON(testCase, Options{StepStartTimestamps: true})
+			Expect(jsonTestCase[0].Steps[0].StartTimestamp).To(Equal("2020-09-13T12:26:41.000Z"))
+		})
+	})
+
 	It("has the Tags", func() {
 		Expect(len(jsonTestCase[0].Tags)).To(Equal(1))
 		Expect(jsonTestCase[0].Tags[0].Line).To(Equal(uint32(3)))
@@ -519,7 +741,7 @@ var _ = Describe("TestCaseToJSON", func() {
 				},
 				testCase.Steps[0],
 			}
-			jsonTestCase = TestCaseToJSON(testCase)
+			jsonTestCase = TestCaseToJSON(testCase, Options{})
 		})
 
 		It("returns two jsonFeatureElements", func() {
@@ -535,6 +757,74 @@ var _ = Describe("TestCaseToJSON", func() {
 		})
 	})
 
+	Context("when the Scenario is in a Rule", func() {
+		BeforeEach(func() {
+			testCase.Rule = &messages.Rule{
+				Name: "My rule",
+			}
+			jsonTestCase = TestCaseToJSON(testCase, Options{})
+		})
+
+		It("does not have the Rule name in the ID, as cucumber-ruby", func() {
+			Expect(jsonTestCase[0].ID).To(Equal("my-feature;a-scenario-(<exampleid>)"))
+		})
+	})
+
+	Context("when the Rule has a Background", func() {
+		BeforeEach(func() {
+			makeBackgroundStep := func(background *messages.Background, line int64) *TestStep {
+				return &TestStep{
+					Step: &messages.Step{
+						Keyword: "Given",
+						Location: &messages.Location{
+							Line: line,
+						},
+					},
+					Pickle: pickle,
+					PickleStep: &messages.PickleStep{
+						Text: "a passed step",
+					},
+					Result: &messages.TestStepResult{
+						Status: messages.TestStepResultStatus_PASSED,
+					},
+					Background: background,
+				}
+			}
+			featureBackground := &messages.Background{
+				Keyword: "Background",
+				Location: &messages.Location{
+					Line: 3,
+				},
+			}
+			ruleBackground := &messages.Background{
+				Keyword: "Background",
+				Name:    "rule background",
+				Location: &messages.Location{
+					Line: 8,
+				},
+			}
+			testCase.Steps = []*TestStep{
+				makeBackgroundStep(featureBackground, 4),
+				makeBackgroundStep(ruleBackground, 9),
+				makeBackgroundStep(ruleBackground, 10),
+				testCase.Steps[0],
+			}
+			jsonTestCase = TestCaseToJSON(testCase, Options{})
+		})
+
+		It("renders the feature and rule Backgrounds as separate elements", func() {
+			Expect(len(jsonTestCase)).To(Equal(3))
+			Expect(jsonTestCase[0].Type).To(Equal("background"))
+			Expect(jsonTestCase[0].Line).To(Equal(uint32(3)))
+			Expect(len(jsonTestCase[0].Steps)).To(Equal(1))
+			Expect(jsonTestCase[1].Type).To(Equal("background"))
+			Expect(jsonTestCase[1].Name).To(Equal("rule background"))
+			Expect(jsonTestCase[1].Line).To(Equal(uint32(8)))
+			Expect(len(jsonTestCase[1].Steps)).To(Equal(2))
+			Expect(jsonTestCase[2].Type).To(Equal("scenario"))
+		})
+	})
+
 	Context("when pickles come from a Examples row", func() {
 		BeforeEach(func() {
 			exampleRow := &messages.TableRow{
@@ -554,7 +844,7 @@ var _ = Describe("TestCaseToJSON", func() {
 			}
 
 			testCase.Pickle.AstNodeIds = append(testCase.Pickle.AstNodeIds, exampleRow.Id)
-			jsonTestCase = TestCaseToJSON(testCase)
+			jsonTestCase = TestCaseToJSON(testCase, Options{})
 		})
 
 		It("has the Examples table name and ExampleRow line in the Id", func() {
@@ -588,7 +878,7 @@ var _ = Describe("TestCaseToJSON", func() {
 				},
 				testCase.Steps[0],
 			}
-			jsonTestCase = TestCaseToJSON(testCase)
+			jsonTestCase = TestCaseToJSON(testCase, Options{})
 
 		})
 
@@ -616,11 +906,57 @@ var _ = Describe("TestCaseToJSON", func() {
 					},
 				},
 			})
-			jsonTestCase = TestCaseToJSON(testCase)
+			jsonTestCase = TestCaseToJSON(testCase, Options{})
 		})
 
 		It("has the hooks in the After section of the last Element", func() {
 			Expect(jsonTestCase[0].After[0].Match.Location).To(Equal("some_hooks.rb:12"))
 		})
 	})
+
+	Context("when there are BeforeStep and AfterStep hooks", func() {
+		makeStepHookStep := func(hookId string, line int64) *TestStep {
+			return &TestStep{
+				Hook: &messages.Hook{
+					Id: hookId,
+					SourceReference: &messages.SourceReference{
+						Uri:      "some_hooks.rb",
+						Location: &messages.Location{Line: line},
+					},
+				},
+				Result: &messages.TestStepResult{
+					Status: messages.TestStepResultStatus_PASSED,
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			step := testCase.Steps[0]
+			otherStep := *step
+			testCase.Steps = []*TestStep{
+				makeStepHookStep("before-step-hook", 3),
+				step,
+				makeStepHookStep("after-step-hook", 8),
+				makeStepHookStep("before-step-hook", 3),
+				&otherStep,
+				makeStepHookStep("after-step-hook", 8),
+			}
+			jsonTestCase = TestCaseToJSON(testCase, Options{})
+		})
+
+		It("has the hooks in the Before and After sections of the steps they wrap", func() {
+			Expect(len(jsonTestCase[0].Steps)).To(Equal(2))
+			for _, jsonStep := range jsonTestCase[0].Steps {
+				Expect(len(jsonStep.Before)).To(Equal(1))
+				Expect(jsonStep.Before[0].Match.Location).To(Equal("some_hooks.rb:3"))
+				Expect(len(jsonStep.After)).To(Equal(1))
+				Expect(jsonStep.After[0].Match.Location).To(Equal("some_hooks.rb:8"))
+			}
+		})
+
+		It("has no hooks in the Before and After sections of the element", func() {
+			Expect(jsonTestCase[0].Before).To(BeEmpty())
+			Expect(jsonTestCase[0].After).To(BeEmpty())
+		})
+	})
 })
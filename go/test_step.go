@@ -2,7 +2,6 @@ package json
 
 import (
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"strings"
 
@@ -10,56 +9,74 @@ import (
 )
 
 type TestStep struct {
-	TestCaseID      string
-	Hook            *messages.Hook
-	Pickle          *messages.Pickle
-	PickleStep      *messages.PickleStep
-	Step            *messages.Step
-	StepDefinitions []*messages.StepDefinition
-	Result          *messages.TestStepResult
-	Background      *messages.Background
-	Attachments     []*messages.Attachment
-	ExampleRow      *messages.TableRow
+	TestCaseID         string
+	TestCaseStartedID  string
+	Hook               *messages.Hook
+	Pickle             *messages.Pickle
+	PickleStep         *messages.PickleStep
+	Step               *messages.Step
+	StepDefinitions    []*messages.StepDefinition
+	StepMatchArguments []*messages.StepMatchArgument
+	Result             *messages.TestStepResult
+	Background         *messages.Background
+	Attachments        []*messages.Attachment
+	ExampleRow         *messages.TableRow
+	StartTimestamp     *messages.Timestamp
 }
 
 func ProcessTestStepFinished(testStepFinished *messages.TestStepFinished, lookup *MessageLookup) (error, *TestStep) {
 	testCaseStarted := lookup.LookupTestCaseStarted(testStepFinished.TestCaseStartedId)
 	if testCaseStarted == nil {
-		return errors.New("No testCaseStarted for " + testStepFinished.TestCaseStartedId), nil
+		return missingReference("TestCaseStarted", testStepFinished.TestCaseStartedId), nil
 	}
 
 	testCase := lookup.LookupTestCase(testCaseStarted.TestCaseId)
 	if testCase == nil {
-		return errors.New("No testCase for " + testCaseStarted.TestCaseId), nil
+		return missingReference("TestCase", testCaseStarted.TestCaseId), nil
 	}
 
 	testStep := lookup.LookupTestStep(testStepFinished.TestStepId)
 	if testStep == nil {
-		return errors.New("No testStep for " + testStepFinished.TestStepId), nil
+		return missingReference("TestStep", testStepFinished.TestStepId), nil
+	}
+
+	result := testStepFinished.TestStepResult
+	if result == nil {
+		result = &messages.TestStepResult{
+			Status: messages.TestStepResultStatus_UNKNOWN,
+		}
+	}
+
+	var startTimestamp *messages.Timestamp
+	testStepStarted := lookup.LookupTestStepStarted(testStepFinished.TestCaseStartedId, testStepFinished.TestStepId)
+	if testStepStarted != nil {
+		startTimestamp = testStepStarted.Timestamp
 	}
 
 	if testStep.HookId != "" {
 		hook := lookup.LookupHook(testStep.HookId)
 		if hook == nil {
-			return errors.New("No hook for " + testStep.HookId), nil
+			return missingReference("Hook", testStep.HookId), nil
 		}
 
 		return nil, &TestStep{
-			TestCaseID:  testCase.Id,
-			Hook:        hook,
-			Result:      testStepFinished.TestStepResult,
-			Attachments: lookup.LookupAttachments(testStepFinished.TestStepId),
+			TestCaseID:        testCase.Id,
+			TestCaseStartedID: testCaseStarted.Id,
+			Hook:              hook,
+			Result:            result,
+			Attachments:       lookup.LookupAttachments(testStepFinished.TestStepId),
+			StartTimestamp:    startTimestamp,
 		}
 	}
 
 	pickle := lookup.LookupPickle(testCase.PickleId)
 	if pickle == nil {
-		return errors.New("No pickle for " + testCase.PickleId), nil
+		return missingReference("Pickle", testCase.PickleId), nil
 	}
 
 	pickleStep := lookup.LookupPickleStep(testStep.PickleStepId)
-	if pickleStep == nil {
-		return errors.New("No pickleStep for " + testStep.PickleStepId), nil
+	if pickleStep == nil || len(pickleStep.AstNodeIds) == 0 {
+		return missingReference("PickleStep", testStep.PickleStepId), nil
 	}
 
 	var exampleRow *messages.TableRow
@@ -67,96 +84,272 @@ func ProcessTestStepFinished(testStepFinished *messages.TestStepFinished, lookup
 		exampleRow = lookup.LookupExampleRow(pickle.AstNodeIds[1])
 	}
 
-	var background *messages.Background
 	scenarioStep := lookup.LookupStep(pickleStep.AstNodeIds[0])
-	if scenarioStep != nil {
-		background = lookup.LookupBackgroundByStepID(scenarioStep.Id)
+	if scenarioStep == nil {
+		return missingReference("Step", pickleStep.AstNodeIds[0]), nil
+	}
+	background := lookup.LookupBackgroundByStepID(scenarioStep.Id)
+
+	stepDefinitions := lookup.LookupStepDefinitions(testStep.StepDefinitionIds)
+	for index, stepDefinition := range stepDefinitions {
+		if stepDefinition == nil {
+			return missingReference("StepDefinition", testStep.StepDefinitionIds[index]), nil
+		}
+	}
+
+	// Only an unambiguous match has a single list of arguments
+	var stepMatchArguments []*messages.StepMatchArgument
+	if len(testStep.StepMatchArgumentsLists) == 1 {
+		stepMatchArguments = testStep.StepMatchArgumentsLists[0].StepMatchArguments
 	}
 
 	return nil, &TestStep{
-		TestCaseID:      testCase.Id,
-		Step:            lookup.LookupStep(pickleStep.AstNodeIds[0]),
-		Pickle:          pickle,
-		PickleStep:      pickleStep,
-		ExampleRow:      exampleRow,
-		Result:          testStepFinished.TestStepResult,
-		StepDefinitions: lookup.LookupStepDefinitions(testStep.StepDefinitionIds),
-		Background:      background,
-		Attachments:     lookup.LookupAttachments(testStepFinished.TestStepId),
+		TestCaseID:         testCase.Id,
+		TestCaseStartedID:  testCaseStarted.Id,
+		StepMatchArguments: stepMatchArguments,
+		Step:               scenarioStep,
+		Pickle:             pickle,
+		PickleStep:         pickleStep,
+		ExampleRow:         exampleRow,
+		Result:             result,
+		StepDefinitions:    stepDefinitions,
+		Background:         background,
+		Attachments:        lookup.LookupAttachments(testStepFinished.TestStepId),
+		StartTimestamp:     startTimestamp,
 	}
 }
 
-func TestStepToJSON(step *TestStep) *jsonStep {
-	status := strings.ToLower(step.Result.Status.String())
-	duration := uint64(0)
-	if step.Result.Duration != nil {
-		duration = uint64(messages.DurationToGoDuration(*step.Result.Duration))
+func TestStepToJSON(step *TestStep, options Options) *jsonStep {
+	profile := options.Dialect.profile()
+	startTimestamp := ""
+	if options.StepStartTimestamps {
+		startTimestamp = makeTimestamp(step.StartTimestamp)
 	}
 
 	if step.Hook != nil {
 		return &jsonStep{
 			Match: &jsonStepMatch{
-				Location: makeSourceReferenceLocation(step.Hook.SourceReference),
-			},
-			Result: &jsonStepResult{
-				Status:       status,
-				ErrorMessage: step.Result.Message,
-				Duration:     duration,
+				Location: makeSourceReferenceLocation(step.Hook.SourceReference, profile),
 			},
-			Embeddings: makeEmbeddings(step.Attachments),
+			Result:         makeJSONStepResult(step, profile),
+			Embeddings:     makeEmbeddings(step.Attachments),
+			StartTimestamp: startTimestamp,
 		}
 	}
 
-	location := makeLocation(step.Pickle.Uri, step.Step.Location.Line)
-	if step.ExampleRow != nil {
-		location = makeLocation(step.Pickle.Uri, step.ExampleRow.Location.Line)
+	jsonStep := &jsonStep{
+		Keyword:        step.Step.Keyword,
+		Name:           step.PickleStep.Text,
+		Line:           uint32(step.Step.Location.Line),
+		Match:          makeJSONStepMatch(step, profile),
+		Result:         makeJSONStepResult(step, profile),
+		Embeddings:     makeEmbeddings(step.Attachments),
+		Output:         makeOutput(step.Attachments),
+		StartTimestamp: startTimestamp,
 	}
 
-	if len(step.StepDefinitions) == 1 {
-		location = makeSourceReferenceLocation(step.StepDefinitions[0].SourceReference)
+	jsonStep.DocString = makeJSONDocString(step)
+	jsonStep.Rows = makeJSONRows(step)
+
+	return jsonStep
+}
+
+// makeJSONStepMatch renders the step definition matching the step. Undefined and
+// ambiguous steps have no such step definition: cucumber-ruby gives the location
+// of the step instead, the other implementations have no match.
+func makeJSONStepMatch(step *TestStep, profile *dialectProfile) *jsonStepMatch {
+	switch step.Result.Status {
+	case messages.TestStepResultStatus_UNDEFINED, messages.TestStepResultStatus_AMBIGUOUS:
+		if !profile.unmatchedStepLocations {
+			return nil
+		}
 	}
 
-	jsonStep := &jsonStep{
-		Keyword: step.Step.Keyword,
-		Name:    step.PickleStep.Text,
-		Line:    uint32(step.Step.Location.Line),
-		Match: &jsonStepMatch{
-			Location: location,
-		},
-		Result: &jsonStepResult{
-			Status:       status,
-			ErrorMessage: step.Result.Message,
-			Duration:     duration,
-		},
-		Embeddings: makeEmbeddings(step.Attachments),
-		Output:     makeOutput(step.Attachments),
+	match := &jsonStepMatch{
+		Location: makeStepMatchLocation(step, profile),
+	}
+	if profile.matchArguments {
+		match.Arguments = makeJSONArguments(step)
 	}
+	return match
+}
+
+// makeJSONArguments renders the values captured by the step definition matching
+// the step, with their offset in the step text. Undefined and ambiguous steps
+// have no such step definition.
+func makeJSONArguments(step *TestStep) []*jsonArgument {
+	switch step.Result.Status {
+	case messages.TestStepResultStatus_UNDEFINED, messages.TestStepResultStatus_AMBIGUOUS:
+		return nil
+	}
+
+	arguments := make([]*jsonArgument, 0, len(step.StepMatchArguments))
+	for _, stepMatchArgument := range step.StepMatchArguments {
+		if stepMatchArgument.Group != nil {
+			arguments = append(arguments, makeJSONArgument(stepMatchArgument.Group))
+		}
+	}
+	return arguments
+}
+
+func makeJSONArgument(group *messages.Group) *jsonArgument {
+	argument := &jsonArgument{
+		Val:    group.Value,
+		Offset: group.Start,
+	}
+	for _, child := range group.Children {
+		argument.Children = append(argument.Children, makeJSONArgument(child))
+	}
+	return argument
+}
 
+// makeJSONDocString renders the DocString of the PickleStep, where the
+// placeholders of Scenario Outlines are substituted, at the line of the
+// DocString in the Gherkin document.
+func makeJSONDocString(step *TestStep) *jsonDocString {
 	docString := step.Step.DocString
-	if docString != nil {
-		jsonStep.DocString = &jsonDocString{
-			Line:        uint32(docString.Location.Line),
-			ContentType: docString.MediaType,
-			Value:       docString.Content,
+	if docString == nil {
+		return nil
+	}
+
+	jsonDocString := &jsonDocString{
+		Line:        uint32(docString.Location.Line),
+		ContentType: docString.MediaType,
+		Value:       docString.Content,
+	}
+
+	argument := step.PickleStep.Argument
+	if argument != nil && argument.DocString != nil {
+		jsonDocString.ContentType = argument.DocString.MediaType
+		jsonDocString.Value = argument.DocString.Content
+	}
+
+	return jsonDocString
+}
+
+// makeJSONRows renders the DataTable of the PickleStep, where the placeholders
+// of Scenario Outlines are substituted.
+func makeJSONRows(step *TestStep) []*jsonDatatableRow {
+	table := makePickleTable(step)
+	if table == nil {
+		return nil
+	}
+
+	rows := make([]*jsonDatatableRow, len(table.Rows))
+	for rowIndex, row := range table.Rows {
+		cells := make([]string, len(row.Cells))
+		for cellIndex, cell := range row.Cells {
+			cells[cellIndex] = cell.Value
+		}
+		rows[rowIndex] = &jsonDatatableRow{
+			Cells: cells,
 		}
 	}
+	return rows
+}
+
+// makePickleTable returns the DataTable of the PickleStep or, when the
+// PickleStep has no argument, the DataTable of the Gherkin Step as it is.
+func makePickleTable(step *TestStep) *messages.PickleTable {
+	argument := step.PickleStep.Argument
+	if argument != nil && argument.DataTable != nil {
+		return argument.DataTable
+	}
 
 	datatable := step.Step.DataTable
-	if datatable != nil {
-		jsonStep.Rows = make([]*jsonDatatableRow, len(datatable.Rows))
-		for rowIndex, row := range datatable.Rows {
-			cells := make([]string, len(row.Cells))
-			for cellIndex, cell := range row.Cells {
-				cells[cellIndex] = cell.Value
-			}
-
-			jsonStep.Rows[rowIndex] = &jsonDatatableRow{
-				Cells: cells,
-			}
+	if datatable == nil {
+		return nil
+	}
+
+	table := &messages.PickleTable{
+		Rows: make([]*messages.PickleTableRow, len(datatable.Rows)),
+	}
+	for rowIndex, row := range datatable.Rows {
+		cells := make([]*messages.PickleTableCell, len(row.Cells))
+		for cellIndex, cell := range row.Cells {
+			cells[cellIndex] = &messages.PickleTableCell{Value: cell.Value}
 		}
+		table.Rows[rowIndex] = &messages.PickleTableRow{Cells: cells}
 	}
+	return table
+}
 
-	return jsonStep
+// makeJSONStepResult renders the result the way cucumber-ruby does: the error
+// message is only given for failed, pending and ambiguous steps, and steps that
+// were not run (skipped or undefined) have no duration unless the dialect
+// gives it.
+func makeJSONStepResult(step *TestStep, profile *dialectProfile) *jsonStepResult {
+	result := &jsonStepResult{
+		Status: makeStatus(step.Result.Status),
+	}
+
+	switch step.Result.Status {
+	case messages.TestStepResultStatus_FAILED, messages.TestStepResultStatus_PENDING:
+		result.ErrorMessage = step.Result.Message
+	case messages.TestStepResultStatus_AMBIGUOUS:
+		result.ErrorMessage = makeAmbiguousErrorMessage(step, profile)
+	}
+
+	switch step.Result.Status {
+	case messages.TestStepResultStatus_SKIPPED,
+		messages.TestStepResultStatus_UNDEFINED,
+		messages.TestStepResultStatus_UNKNOWN:
+		if !profile.durationOfStepsNotRun {
+			break
+		}
+		fallthrough
+	default:
+		if step.Result.Duration != nil {
+			result.Duration = uint64(messages.DurationToGoDuration(*step.Result.Duration))
+		}
+	}
+
+	return result
+}
+
+func makeStatus(status messages.TestStepResultStatus) string {
+	// cucumber-ruby has no "unknown" status: a step without a known
+	// result is reported as undefined
+	if status == messages.TestStepResultStatus_UNKNOWN {
+		return "undefined"
+	}
+	return strings.ToLower(status.String())
+}
+
+// makeAmbiguousErrorMessage returns the message of the result, which lists the
+// matching step definitions already. Without one, it makes the message listing
+// them.
+func makeAmbiguousErrorMessage(step *TestStep, profile *dialectProfile) string {
+	if step.Result.Message != "" {
+		return step.Result.Message
+	}
+
+	message := fmt.Sprintf("Multiple step definitions match %q:", step.PickleStep.Text)
+	locations := make([]string, len(step.StepDefinitions))
+	for index, stepDefinition := range step.StepDefinitions {
+		locations[index] = makeSourceReferenceLocation(stepDefinition.SourceReference, profile)
+	}
+
+	return fmt.Sprintf("%s\n\n%s", message, strings.Join(locations, "\n"))
+}
+
+// makeStepMatchLocation returns the location of the step definition matching
+// the step. Undefined and ambiguous steps have no such step definition: their
+// location is the one of the step in the feature file.
+func makeStepMatchLocation(step *TestStep, profile *dialectProfile) string {
+	switch step.Result.Status {
+	case messages.TestStepResultStatus_UNDEFINED, messages.TestStepResultStatus_AMBIGUOUS:
+		return makeLocation(step.Pickle.Uri, step.Step.Location.Line)
+	}
+
+	if len(step.StepDefinitions) == 1 {
+		return makeSourceReferenceLocation(step.StepDefinitions[0].SourceReference, profile)
+	}
+
+	if step.ExampleRow != nil {
+		return makeLocation(step.Pickle.Uri, step.ExampleRow.Location.Line)
+	}
+	return makeLocation(step.Pickle.Uri, step.Step.Location.Line)
 }
 
 func makeEmbeddings(attachments []*messages.Attachment) []*jsonEmbedding {
@@ -219,17 +412,29 @@ func makeJavaMethodLocation(
 	return fmt.Sprintf("%s.%s(%s)", javaMethod.ClassName, javaMethod.MethodName, typeList)
 }
 
+// makeJavaStackTraceElementLocation renders the location of a lambda as
+// FileName:line or, for the dialects with javaLocations, as Java renders a
+// stack trace element: ClassName.MethodName(FileName:line)
 func makeJavaStackTraceElementLocation(
 	javaStackTraceElement *messages.JavaStackTraceElement,
 	location *messages.Location,
+	profile *dialectProfile,
 ) string {
+	fileLocation := javaStackTraceElement.FileName
 	if location != nil {
-		return makeLocation(javaStackTraceElement.FileName, location.Line)
+		fileLocation = makeLocation(javaStackTraceElement.FileName, location.Line)
+	}
+	if profile.javaLocations {
+		return fmt.Sprintf("%s.%s(%s)", javaStackTraceElement.ClassName, javaStackTraceElement.MethodName, fileLocation)
 	}
-	return javaStackTraceElement.FileName
+	return fileLocation
 }
 
-func makeSourceReferenceLocation(sourceReference *messages.SourceReference) string {
+func makeSourceReferenceLocation(sourceReference *messages.SourceReference, profile *dialectProfile) string {
+	if sourceReference == nil {
+		return ""
+	}
+
 	javaMethod := sourceReference.JavaMethod
 	if javaMethod != nil {
 		return makeJavaMethodLocation(javaMethod)
@@ -238,8 +443,11 @@ func makeSourceReferenceLocation(sourceReference *messages.SourceReference) stri
 	location := sourceReference.Location
 	javaStackTraceElement := sourceReference.JavaStackTraceElement
 	if javaStackTraceElement != nil {
-		return makeJavaStackTraceElementLocation(javaStackTraceElement, location)
+		return makeJavaStackTraceElementLocation(javaStackTraceElement, location, profile)
 	}
 
+	if location == nil {
+		return sourceReference.Uri
+	}
 	return makeLocation(sourceReference.Uri, location.Line)
 }
@@ -15,9 +15,13 @@ var _ = Describe("ProcessTestStepFinished", func() {
 		lookup = &MessageLookup{}
 		lookup.Initialize(false)
 
+		// This is a bit dirty hack to avoid creating all the AST
+		step := makeGherkinStep("some-id", "Given", "a step")
+		lookup.stepByID[step.Id] = step
+
 		pickleStep := &messages.PickleStep{
 			Id:         "pickle-step-id",
-			AstNodeIds: []string{"some-id"},
+			AstNodeIds: []string{step.Id},
 		}
 		pickle := &messages.Pickle{
 			Id:    "pickle-id",
@@ -74,6 +78,18 @@ var _ = Describe("ProcessTestStepFinished", func() {
 			Expect(testStep).To(BeNil())
 		})
 
+		It("returns a MissingReferenceError if the TestCaseStarted does not exist", func() {
+			testStepFinished := &messages.TestStepFinished{
+				TestCaseStartedId: "unknown-test-case-started",
+				TestStepId:        "test-step-id",
+			}
+			err, _ := ProcessTestStepFinished(testStepFinished, lookup)
+			Expect(err).To(Equal(&MissingReferenceError{
+				Kind: "TestCaseStarted",
+				ID:   "unknown-test-case-started",
+			}))
+		})
+
 		It("returns nil if the TestCaseStarted references an unknown TestCase", func() {
 			testCaseStarted := &messages.TestCaseStarted{
 				Id:         "test-case-started-no-test-case",
@@ -129,6 +145,35 @@ var _ = Describe("ProcessTestStepFinished", func() {
 			Expect(testStep.Result.Status).To(Equal(messages.TestStepResultStatus_PASSED))
 		})
 
+		It("returns a TestStep with the start time of the TestStepStarted", func() {
+			lookup.ProcessMessage(&messages.Envelope{
+				TestStepStarted: &messages.TestStepStarted{
+					TestCaseStartedId: "test-case-started-id",
+					TestStepId:        "hook-step-id",
+					Timestamp:         &messages.Timestamp{Seconds: 1600000000},
+				},
+			})
+			testStepFinished := &messages.TestStepFinished{
+				TestCaseStartedId: "test-case-started-id",
+				TestStepId:        "hook-step-id",
+			}
+
+			_, testStep := ProcessTestStepFinished(testStepFinished, lookup)
+
+			Expect(testStep.StartTimestamp).To(Equal(&messages.Timestamp{Seconds: 1600000000}))
+		})
+
+		It("returns a TestStep without start time when no TestStepStarted was received", func() {
+			testStepFinished := &messages.TestStepFinished{
+				TestCaseStartedId: "test-case-started-id",
+				TestStepId:        "hook-step-id",
+			}
+
+			_, testStep := ProcessTestStepFinished(testStepFinished, lookup)
+
+			Expect(testStep.StartTimestamp).To(BeNil())
+		})
+
 		It("returns a TestStep with a nil Step", func() {
 			testStepFinished := &messages.TestStepFinished{
 				TestCaseStartedId: "test-case-started-id",
@@ -202,12 +247,20 @@ var _ = Describe("ProcessTestStepFinished", func() {
 			}
 			lookup.ProcessMessage(makePickleEnvelope(pickle))
 
+			testStep := makeTestStep("test-step-id", pickleStep.Id, []string{"step-def-id"})
+			testStep.StepMatchArgumentsLists = []*messages.StepMatchArgumentsList{
+				{
+					StepMatchArguments: []*messages.StepMatchArgument{
+						{Group: &messages.Group{Start: 9, Value: "step"}},
+					},
+				},
+			}
 			testCase := makeTestCase(
 				"test-case-id",
 				pickle.Id,
 				[]*messages.TestStep{
 					makeTestStep("background-step-id", backgroundPickleStep.Id, []string{"step-def-id"}),
-					makeTestStep("test-step-id", pickleStep.Id, []string{"step-def-id"}),
+					testStep,
 					makeTestStep("unknown-pickle", "unknown-pickle-step-id", []string{}),
 				},
 			)
@@ -240,6 +293,16 @@ var _ = Describe("ProcessTestStepFinished", func() {
 			Expect(testStep.StepDefinitions[0].Pattern.Source).To(Equal("a passed {word}"))
 		})
 
+		It("returns a Step including the StepMatchArguments", func() {
+			testStepFinished := &messages.TestStepFinished{
+				TestStepId:        "test-step-id",
+				TestCaseStartedId: testCaseStarted.Id,
+			}
+			_, testStep := ProcessTestStepFinished(testStepFinished, lookup)
+			Expect(len(testStep.StepMatchArguments)).To(Equal(1))
+			Expect(testStep.StepMatchArguments[0].Group.Value).To(Equal("step"))
+		})
+
 		It("the Step is not marked as a Background step by default", func() {
 			testStepFinished := &messages.TestStepFinished{
 				TestStepId:        "test-step-id",
@@ -293,6 +356,43 @@ var _ = Describe("ProcessTestStepFinished", func() {
 				_, testStep := ProcessTestStepFinished(testStepFinished, lookup)
 				Expect(testStep).To(BeNil())
 			})
+
+			It("returns a MissingReferenceError if the Step is unknown", func() {
+				pickleStep := &messages.PickleStep{
+					Id:         "unknown-step-pickle-step-id",
+					AstNodeIds: []string{"unknown-step-id"},
+				}
+				lookup.pickleStepByID[pickleStep.Id] = pickleStep
+				lookup.testStepByID["unknown-step-test-step-id"] = makeTestStep("unknown-step-test-step-id", pickleStep.Id, []string{})
+
+				testStepFinished := &messages.TestStepFinished{
+					TestStepId:        "unknown-step-test-step-id",
+					TestCaseStartedId: testCaseStarted.Id,
+				}
+
+				err, testStep := ProcessTestStepFinished(testStepFinished, lookup)
+				Expect(testStep).To(BeNil())
+				Expect(err).To(Equal(&MissingReferenceError{
+					Kind: "Step",
+					ID:   "unknown-step-id",
+				}))
+			})
+
+			It("returns a MissingReferenceError if a StepDefinition is unknown", func() {
+				lookup.testStepByID["unknown-step-def-test-step-id"] = makeTestStep("unknown-step-def-test-step-id", "pickle-step-id", []string{"unknown-step-def-id"})
+
+				testStepFinished := &messages.TestStepFinished{
+					TestStepId:        "unknown-step-def-test-step-id",
+					TestCaseStartedId: testCaseStarted.Id,
+				}
+
+				err, testStep := ProcessTestStepFinished(testStepFinished, lookup)
+				Expect(testStep).To(BeNil())
+				Expect(err).To(Equal(&MissingReferenceError{
+					Kind: "StepDefinition",
+					ID:   "unknown-step-def-id",
+				}))
+			})
 		})
 	})
 })
@@ -331,7 +431,7 @@ var _ = Describe("TestStepToJSON", func() {
 				ContentEncoding: messages.AttachmentContentEncoding_BASE64,
 			})
 
-			jsonStep = TestStepToJSON(step)
+			jsonStep = TestStepToJSON(step, Options{})
 		})
 
 		It("Has a Match", func() {
@@ -386,7 +486,7 @@ var _ = Describe("TestStepToJSON", func() {
 				ContentEncoding: messages.AttachmentContentEncoding_BASE64,
 			})
 
-			jsonStep = TestStepToJSON(step)
+			jsonStep = TestStepToJSON(step, Options{})
 		})
 
 		It("gets keyword from Step", func() {
@@ -415,6 +515,86 @@ var _ = Describe("TestStepToJSON", func() {
 			Expect(jsonStep.Embeddings[0].Data).To(Equal("Hello"))
 		})
 
+		It("has no DocString nor Rows", func() {
+			Expect(jsonStep.DocString).To(BeNil())
+			Expect(jsonStep.Rows).To(BeNil())
+		})
+
+		Context("When it has a DocString", func() {
+			BeforeEach(func() {
+				step.Step.DocString = &messages.DocString{
+					MediaType: "text/<type>",
+					Content:   "The <status> content",
+					Location: &messages.Location{
+						Line: 6,
+					},
+				}
+			})
+
+			It("has the DocString of the PickleStep", func() {
+				step.PickleStep.Argument = &messages.PickleStepArgument{
+					DocString: &messages.PickleDocString{
+						MediaType: "text/plain",
+						Content:   "The passed content",
+					},
+				}
+				jsonStep = TestStepToJSON(step, Options{})
+
+				Expect(jsonStep.DocString.Value).To(Equal("The passed content"))
+				Expect(jsonStep.DocString.ContentType).To(Equal("text/plain"))
+				Expect(jsonStep.DocString.Line).To(Equal(uint32(6)))
+			})
+
+			It("has the DocString of the Step when the PickleStep has no argument", func() {
+				jsonStep = TestStepToJSON(step, Options{})
+
+				Expect(jsonStep.DocString.Value).To(Equal("The <status> content"))
+				Expect(jsonStep.DocString.ContentType).To(Equal("text/<type>"))
+				Expect(jsonStep.DocString.Line).To(Equal(uint32(6)))
+			})
+		})
+
+		Context("When it has a DataTable", func() {
+			BeforeEach(func() {
+				step.Step.DataTable = &messages.DataTable{
+					Rows: []*messages.TableRow{
+						{
+							Cells: []*messages.TableCell{
+								{Value: "status"},
+								{Value: "<status>"},
+							},
+						},
+					},
+				}
+			})
+
+			It("has the Rows of the PickleStep", func() {
+				step.PickleStep.Argument = &messages.PickleStepArgument{
+					DataTable: &messages.PickleTable{
+						Rows: []*messages.PickleTableRow{
+							{
+								Cells: []*messages.PickleTableCell{
+									{Value: "status"},
+									{Value: "passed"},
+								},
+							},
+						},
+					},
+				}
+				jsonStep = TestStepToJSON(step, Options{})
+
+				Expect(len(jsonStep.Rows)).To(Equal(1))
+				Expect(jsonStep.Rows[0].Cells).To(Equal([]string{"status", "passed"}))
+			})
+
+			It("has the Rows of the Step when the PickleStep has no argument", func() {
+				jsonStep = TestStepToJSON(step, Options{})
+
+				Expect(len(jsonStep.Rows)).To(Equal(1))
+				Expect(jsonStep.Rows[0].Cells).To(Equal([]string{"status", "<status>"}))
+			})
+		})
+
 		Context("When it does not have a StepDefinition", func() {
 			It("Has a Match referencing the feature file", func() {
 				Expect(jsonStep.Match.Location).To(Equal("my_feature.feature:5"))
@@ -434,10 +614,64 @@ var _ = Describe("TestStepToJSON", func() {
 					},
 				}
 
-				jsonStep = TestStepToJSON(step)
+				jsonStep = TestStepToJSON(step, Options{})
 				Expect(jsonStep.Match.Location).To(Equal("support_code.go:12"))
 			})
 		})
+
+		Context("When it has StepMatchArguments", func() {
+			BeforeEach(func() {
+				step.StepMatchArguments = []*messages.StepMatchArgument{
+					{
+						Group: &messages.Group{
+							Start: 2,
+							Value: "passed",
+						},
+					},
+					{
+						Group: &messages.Group{
+							Start: 9,
+							Value: "step",
+							Children: []*messages.Group{
+								{Start: 9, Value: "st"},
+							},
+						},
+					},
+				}
+			})
+
+			It("has the captured values and their offset in the Match arguments", func() {
+				jsonStep = TestStepToJSON(step, Options{})
+
+				Expect(len(jsonStep.Match.Arguments)).To(Equal(2))
+				Expect(jsonStep.Match.Arguments[0].Val).To(Equal("passed"))
+				Expect(jsonStep.Match.Arguments[0].Offset).To(Equal(int64(2)))
+				Expect(jsonStep.Match.Arguments[0].Children).To(BeEmpty())
+				Expect(jsonStep.Match.Arguments[1].Val).To(Equal("step"))
+				Expect(jsonStep.Match.Arguments[1].Offset).To(Equal(int64(9)))
+			})
+
+			It("has the nested groups as children", func() {
+				jsonStep = TestStepToJSON(step, Options{})
+
+				Expect(len(jsonStep.Match.Arguments[1].Children)).To(Equal(1))
+				Expect(jsonStep.Match.Arguments[1].Children[0].Val).To(Equal("st"))
+				Expect(jsonStep.Match.Arguments[1].Children[0].Offset).To(Equal(int64(9)))
+			})
+
+			It("has no arguments in the dialects which do not write them", func() {
+				jsonStep = TestStepToJSON(step, Options{Dialect: DialectRuby})
+
+				Expect(jsonStep.Match.Arguments).To(BeEmpty())
+			})
+
+			It("has no arguments when the step is ambiguous", func() {
+				step.Result.Status = messages.TestStepResultStatus_AMBIGUOUS
+				jsonStep = TestStepToJSON(step, Options{})
+
+				Expect(jsonStep.Match.Arguments).To(BeEmpty())
+			})
+		})
 	})
 
 	Context("When SourceReference uses JavaMethod and no Location", func() {
@@ -464,7 +698,7 @@ var _ = Describe("TestStepToJSON", func() {
 						},
 					},
 				}
-				jsonStep = TestStepToJSON(step)
+				jsonStep = TestStepToJSON(step, Options{})
 			})
 
 			It("Has a Match", func() {
@@ -511,7 +745,7 @@ var _ = Describe("TestStepToJSON", func() {
 						},
 					},
 				}
-				jsonStep = TestStepToJSON(step)
+				jsonStep = TestStepToJSON(step, Options{})
 			})
 
 			It("Has a Match", func() {
@@ -544,7 +778,7 @@ var _ = Describe("TestStepToJSON", func() {
 						},
 					},
 				}
-				jsonStep = TestStepToJSON(step)
+				jsonStep = TestStepToJSON(step, Options{})
 			})
 
 			It("Has a Match", func() {
@@ -591,7 +825,7 @@ var _ = Describe("TestStepToJSON", func() {
 						},
 					},
 				}
-				jsonStep = TestStepToJSON(step)
+				jsonStep = TestStepToJSON(step, Options{})
 			})
 
 			It("Has a Match", func() {
@@ -621,7 +855,7 @@ var _ = Describe("TestStepToJSON", func() {
 						},
 					},
 				}
-				jsonStep = TestStepToJSON(step)
+				jsonStep = TestStepToJSON(step, Options{})
 			})
 
 			It("Has a Match", func() {
@@ -665,7 +899,7 @@ var _ = Describe("TestStepToJSON", func() {
 						},
 					},
 				}
-				jsonStep = TestStepToJSON(step)
+				jsonStep = TestStepToJSON(step, Options{})
 			})
 
 			It("Has a Match", func() {
@@ -674,4 +908,156 @@ var _ = Describe("TestStepToJSON", func() {
 		})
 	})
 
+	Context("When the step is not passed or failed", func() {
+		var makeStep func(status messages.TestStepResultStatus, message string) *TestStep
+
+		BeforeEach(func() {
+			makeStep = func(status messages.TestStepResultStatus, message string) *TestStep {
+				return &TestStep{
+					Step: &messages.Step{
+						Keyword: "Given",
+						Location: &messages.Location{
+							Line: 5,
+						},
+					},
+					Pickle: &messages.Pickle{
+						Uri: "my_feature.feature",
+					},
+					PickleStep: &messages.PickleStep{
+						Text: "a step",
+					},
+					ExampleRow: &messages.TableRow{
+						Location: &messages.Location{
+							Line: 12,
+						},
+					},
+					StepDefinitions: []*messages.StepDefinition{
+						{
+							SourceReference: &messages.SourceReference{
+								Uri: "support_code.go",
+								Location: &messages.Location{
+									Line: 12,
+								},
+							},
+						},
+					},
+					Result: &messages.TestStepResult{
+						Status:  status,
+						Message: message,
+						Duration: &messages.Duration{
+							Seconds: 1,
+						},
+					},
+				}
+			}
+		})
+
+		Context("pending", func() {
+			BeforeEach(func() {
+				jsonStep = TestStepToJSON(makeStep(messages.TestStepResultStatus_PENDING, "TODO"), Options{})
+			})
+
+			It("has the pending status", func() {
+				Expect(jsonStep.Result.Status).To(Equal("pending"))
+			})
+
+			It("has the error message", func() {
+				Expect(jsonStep.Result.ErrorMessage).To(Equal("TODO"))
+			})
+
+			It("has a Duration", func() {
+				Expect(jsonStep.Result.Duration).To(Equal(uint64(1000000000)))
+			})
+
+			It("has a Match referencing the step definition", func() {
+				Expect(jsonStep.Match.Location).To(Equal("support_code.go:12"))
+			})
+		})
+
+		Context("skipped", func() {
+			BeforeEach(func() {
+				jsonStep = TestStepToJSON(makeStep(messages.TestStepResultStatus_SKIPPED, "skipped"), Options{})
+			})
+
+			It("has the skipped status", func() {
+				Expect(jsonStep.Result.Status).To(Equal("skipped"))
+			})
+
+			It("has no error message", func() {
+				Expect(jsonStep.Result.ErrorMessage).To(Equal(""))
+			})
+
+			It("has no Duration", func() {
+				Expect(jsonStep.Result.Duration).To(Equal(uint64(0)))
+			})
+
+			It("has a Match referencing the step definition", func() {
+				Expect(jsonStep.Match.Location).To(Equal("support_code.go:12"))
+			})
+		})
+
+		Context("undefined", func() {
+			BeforeEach(func() {
+				step := makeStep(messages.TestStepResultStatus_UNDEFINED, "")
+				step.StepDefinitions = []*messages.StepDefinition{}
+				jsonStep = TestStepToJSON(step, Options{})
+			})
+
+			It("has the undefined status", func() {
+				Expect(jsonStep.Result.Status).To(Equal("undefined"))
+			})
+
+			It("has no Duration", func() {
+				Expect(jsonStep.Result.Duration).To(Equal(uint64(0)))
+			})
+
+			It("has a Match referencing the step in the feature file", func() {
+				Expect(jsonStep.Match.Location).To(Equal("my_feature.feature:5"))
+			})
+		})
+
+		Context("unknown", func() {
+			It("is reported as undefined", func() {
+				jsonStep = TestStepToJSON(makeStep(messages.TestStepResultStatus_UNKNOWN, ""), Options{})
+				Expect(jsonStep.Result.Status).To(Equal("undefined"))
+			})
+		})
+
+		Context("ambiguous", func() {
+			var step *TestStep
+
+			BeforeEach(func() {
+				step = makeStep(messages.TestStepResultStatus_AMBIGUOUS, "")
+				step.StepDefinitions = append(step.StepDefinitions, &messages.StepDefinition{
+					SourceReference: &messages.SourceReference{
+						Uri: "other_support_code.go",
+						Location: &messages.Location{
+							Line: 3,
+						},
+					},
+				})
+				jsonStep = TestStepToJSON(step, Options{})
+			})
+
+			It("has the ambiguous status", func() {
+				Expect(jsonStep.Result.Status).To(Equal("ambiguous"))
+			})
+
+			It("has a Match referencing the step in the feature file", func() {
+				Expect(jsonStep.Match.Location).To(Equal("my_feature.feature:5"))
+			})
+
+			It("lists every candidate step definition in the error message", func() {
+				Expect(jsonStep.Result.ErrorMessage).To(Equal(
+					"Multiple step definitions match \"a step\":\n\nsupport_code.go:12\nother_support_code.go:3",
+				))
+			})
+
+			It("keeps the message of the result, which lists the step definitions already", func() {
+				step.Result.Message = "Multiple step definitions match \"a step\":\n\nsupport_code.go:12\nother_support_code.go:3"
+				jsonStep = TestStepToJSON(step, Options{})
+				Expect(jsonStep.Result.ErrorMessage).To(Equal(step.Result.Message))
+			})
+		})
+	})
 })